@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/tushardag/pcf-eventalert-integration/handlers"
+)
+
+// requestTimeout : a test alert waits on Teams or PagerDuty, leave it room
+const requestTimeout = 60 * time.Second
+
+// client : calls the /api/v1 endpoints of one server
+type client struct {
+	server string
+	token  string
+	http   *http.Client
+}
+
+// apiFailure : an error envelope answered by the server
+type apiFailure struct {
+	status int
+	handlers.APIError
+}
+
+func (failure *apiFailure) Error() string {
+	message := fmt.Sprintf("%s (HTTP %d, %s)", failure.Message, failure.status, failure.Code)
+	if failure.RequestID != "" {
+		message += ", request " + failure.RequestID
+	}
+	return message
+}
+
+// details : decode the details of the failure into v, false when there are none
+func (failure *apiFailure) details(v interface{}) bool {
+	if failure.Details == nil {
+		return false
+	}
+	raw, err := json.Marshal(failure.Details)
+	if err != nil {
+		return false
+	}
+	return json.Unmarshal(raw, v) == nil
+}
+
+func newClient(server string, token string) *client {
+	return &client{
+		server: strings.TrimSuffix(server, "/"),
+		token:  token,
+		http:   &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// mappingPath : API path of a single mapping
+func mappingPath(routeType string, identifier string) string {
+	return "/mappings/" + url.PathEscape(routeType) + "/" + url.PathEscape(identifier)
+}
+
+// call : send a request to path under /api/v1 and decode the data of the answer into out, when given
+func (c *client) call(method string, path string, query url.Values, body io.Reader, contentType string, out interface{}) error {
+	target := c.server + handlers.APIPrefix + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode >= 300 {
+		var envelope handlers.ErrorEnvelope
+		if err := json.Unmarshal(content, &envelope); err != nil || envelope.Error.Code == "" {
+			return fmt.Errorf("%s %s: HTTP %d %s", method, path, resp.StatusCode, strings.TrimSpace(string(content)))
+		}
+		return &apiFailure{status: resp.StatusCode, APIError: envelope.Error}
+	}
+	if out == nil || resp.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	var envelope struct {
+		Data json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(content, &envelope); err != nil {
+		return fmt.Errorf("%s %s: unexpected answer: %v", method, path, err)
+	}
+	return json.Unmarshal(envelope.Data, out)
+}
+
+// send : call with a JSON body
+func (c *client) send(method string, path string, query url.Values, body interface{}, out interface{}) error {
+	if body == nil {
+		return c.call(method, path, query, nil, "", out)
+	}
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	return c.call(method, path, query, bytes.NewReader(encoded), "application/json", out)
+}
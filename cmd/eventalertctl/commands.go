@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/tushardag/pcf-eventalert-integration/handlers"
+	"gopkg.in/yaml.v3"
+)
+
+func routesList(inv *invocation, args []string) error {
+	if _, err := inv.parse(inv.flagSet("routes list"), args, 0); err != nil {
+		return err
+	}
+	c, err := inv.client()
+	if err != nil {
+		return err
+	}
+	var mappings []*handlers.Mapping
+	if err := c.send("GET", "/mappings", nil, nil, &mappings); err != nil {
+		return err
+	}
+	return inv.render(mappings, func(tw *tabwriter.Writer) {
+		row(tw, "IDENTIFIER", "TYPE", "ENDPOINT", "DESCRIPTION", "VERSION")
+		for _, mapping := range mappings {
+			row(tw, mapping.Identifier, mapping.RouteType, handlers.MaskSecret(mapping.PostURL), mapping.Description, mapping.Version)
+		}
+	})
+}
+
+func routesGet(inv *invocation, args []string) error {
+	positional, err := inv.parse(inv.flagSet("routes get"), args, 2)
+	if err != nil {
+		return err
+	}
+	c, err := inv.client()
+	if err != nil {
+		return err
+	}
+	var mapping handlers.Mapping
+	if err := c.send("GET", mappingPath(positional[0], positional[1]), nil, nil, &mapping); err != nil {
+		return err
+	}
+	return inv.renderMapping(&mapping)
+}
+
+func routesCreate(inv *invocation, args []string) error {
+	fs := inv.flagSet("routes create")
+	endpoint := fs.String("url", "", "")
+	description := fs.String("description", "", "")
+	positional, err := inv.parse(fs, args, 2)
+	if err != nil {
+		return err
+	}
+	if *endpoint == "" {
+		return usageError("routes create: --url is required, the Teams webhook URL or the PagerDuty integration key")
+	}
+	c, err := inv.client()
+	if err != nil {
+		return err
+	}
+	var mapping handlers.Mapping
+	body := handlers.MappingRequest{URL: *endpoint, Description: *description}
+	if err := c.send("PUT", mappingPath(positional[0], positional[1]), nil, body, &mapping); err != nil {
+		return err
+	}
+	return inv.renderMapping(&mapping)
+}
+
+func routesDelete(inv *invocation, args []string) error {
+	positional, err := inv.parse(inv.flagSet("routes delete"), args, 2)
+	if err != nil {
+		return err
+	}
+	c, err := inv.client()
+	if err != nil {
+		return err
+	}
+	if err := c.send("DELETE", mappingPath(positional[0], positional[1]), nil, nil, nil); err != nil {
+		return err
+	}
+	fmt.Fprintf(inv.stdout, "Deleted the %s mapping of %s\n", positional[0], positional[1])
+	return nil
+}
+
+// routesExport : YAML in the layout of the notifications block unless JSON is asked for
+func routesExport(inv *invocation, args []string) error {
+	fs := inv.flagSet("routes export")
+	file := fs.String("file", "", "")
+	if _, err := inv.parse(fs, args, 0); err != nil {
+		return err
+	}
+	c, err := inv.client()
+	if err != nil {
+		return err
+	}
+	var export handlers.MappingExport
+	if err := c.send("GET", "/mappings/export", nil, nil, &export); err != nil {
+		return err
+	}
+	var content bytes.Buffer
+	if inv.opts.output == outputJSON {
+		enc := json.NewEncoder(&content)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(export)
+	} else {
+		enc := yaml.NewEncoder(&content)
+		enc.SetIndent(2)
+		if err = enc.Encode(export); err == nil {
+			err = enc.Close()
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if *file == "" {
+		_, err = inv.stdout.Write(content.Bytes())
+		return err
+	}
+	if err := ioutil.WriteFile(*file, content.Bytes(), 0600); err != nil {
+		return err
+	}
+	fmt.Fprintf(inv.stderr, "Exported %d notifications to %s\n", len(export.Notifications), *file)
+	return nil
+}
+
+func routesImport(inv *invocation, args []string) error {
+	fs := inv.flagSet("routes import")
+	mode := fs.String("mode", "merge", "")
+	dryRun := fs.Bool("dry-run", false, "")
+	positional, err := inv.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	content, err := inv.readInput(positional[0])
+	if err != nil {
+		return err
+	}
+	c, err := inv.client()
+	if err != nil {
+		return err
+	}
+	contentType := "application/x-yaml"
+	if strings.EqualFold(filepath.Ext(positional[0]), ".json") {
+		contentType = "application/json"
+	}
+	query := url.Values{"mode": {*mode}, "dry_run": {strconv.FormatBool(*dryRun)}}
+	var report handlers.ImportReport
+	err = c.call("POST", "/mappings/import", query, bytes.NewReader(content), contentType, &report)
+	var failure *apiFailure
+	if errors.As(err, &failure) && failure.details(&report) {
+		// The entries tell what is wrong with the file
+		if renderErr := inv.renderImport(&report); renderErr != nil {
+			return renderErr
+		}
+	} else if err == nil {
+		err = inv.renderImport(&report)
+	}
+	return err
+}
+
+func preview(inv *invocation, args []string) error {
+	positional, err := inv.parse(inv.flagSet("preview"), args, 3)
+	if err != nil {
+		return err
+	}
+	alert, err := inv.readInput(positional[2])
+	if err != nil {
+		return err
+	}
+	c, err := inv.client()
+	if err != nil {
+		return err
+	}
+	var result handlers.AlertPreview
+	path := "/preview/" + url.PathEscape(positional[0]) + "/" + url.PathEscape(positional[1])
+	if err := c.call("POST", path, nil, bytes.NewReader(alert), "application/json", &result); err != nil {
+		return err
+	}
+	return inv.render(&result, func(tw *tabwriter.Writer) {
+		row(tw, "Endpoint:", result.Endpoint)
+		row(tw, "Mapping version:", result.MappingVersion)
+		fmt.Fprintln(tw)
+		row(tw, "FIELD", "VALUE", "SOURCE")
+		for _, decision := range result.Decisions {
+			row(tw, decision.Field, decision.Value, decision.Source)
+		}
+		tw.Flush()
+		for _, warning := range result.Warnings {
+			fmt.Fprintf(inv.stdout, "\nWarning: %s", warning)
+		}
+		var payload bytes.Buffer
+		if json.Indent(&payload, result.Payload, "", "  ") == nil {
+			fmt.Fprintf(inv.stdout, "\n\nPayload:\n%s\n", payload.String())
+		}
+	})
+}
+
+// testAlert : a failed delivery still shows what Teams or PagerDuty answered
+func testAlert(inv *invocation, args []string) error {
+	positional, err := inv.parse(inv.flagSet("test"), args, 2)
+	if err != nil {
+		return err
+	}
+	c, err := inv.client()
+	if err != nil {
+		return err
+	}
+	var result handlers.TestAlertResult
+	err = c.send("POST", mappingPath(positional[0], positional[1])+"/test", nil, nil, &result)
+	var failure *apiFailure
+	if errors.As(err, &failure) && !failure.details(&result) {
+		return err
+	}
+	if renderErr := inv.render(&result, func(tw *tabwriter.Writer) {
+		row(tw, "ACTION", "STATUS", "ERROR")
+		for _, step := range result.Steps {
+			row(tw, step.Action, step.Status, step.Error)
+		}
+		row(tw)
+		row(tw, "Endpoint:", result.Endpoint)
+		row(tw, "Delivered:", result.Delivered)
+	}); renderErr != nil {
+		return renderErr
+	}
+	return err
+}
+
+func profilesList(inv *invocation, args []string) error {
+	if _, err := inv.parse(inv.flagSet("profiles list"), args, 0); err != nil {
+		return err
+	}
+	config, err := inv.loadConfig()
+	if err != nil {
+		return err
+	}
+	type listed struct {
+		Name     string `json:"name"`
+		Server   string `json:"server"`
+		TokenEnv string `json:"tokenEnv,omitempty"`
+		HasToken bool   `json:"hasToken"`
+		Current  bool   `json:"current"`
+	}
+	profiles := []listed{}
+	for _, name := range config.profileNames() {
+		p := config.Profiles[name]
+		profiles = append(profiles, listed{Name: name, Server: p.Server, TokenEnv: p.TokenEnv,
+			HasToken: p.Token != "" || p.TokenEnv != "", Current: name == config.Current})
+	}
+	return inv.render(profiles, func(tw *tabwriter.Writer) {
+		row(tw, "CURRENT", "NAME", "SERVER", "TOKEN")
+		for _, p := range profiles {
+			current, token := "", "none"
+			if p.Current {
+				current = "*"
+			}
+			if p.TokenEnv != "" {
+				token = "$" + p.TokenEnv
+			} else if p.HasToken {
+				token = "in config"
+			}
+			row(tw, current, p.Name, p.Server, token)
+		}
+	})
+}
+
+// profilesSet : add or change a profile from --server, --token and --token-env
+func profilesSet(inv *invocation, args []string) error {
+	fs := inv.flagSet("profiles set")
+	tokenVariable := fs.String("token-env", "", "")
+	positional, err := inv.parse(fs, args, 1)
+	if err != nil {
+		return err
+	}
+	config, err := inv.loadConfig()
+	if err != nil {
+		return err
+	}
+	name := positional[0]
+	p, ok := config.Profiles[name]
+	if !ok {
+		p = &profile{}
+		config.Profiles[name] = p
+	}
+	if inv.opts.server != "" {
+		if _, err := url.ParseRequestURI(inv.opts.server); err != nil {
+			return usageError("profiles set: --server must be a URL, e.g. https://eventalert-integration.apps.example.com")
+		}
+		p.Server = inv.opts.server
+	}
+	if p.Server == "" {
+		return usageError("profiles set: --server is required for a new profile")
+	}
+	if inv.opts.token != "" {
+		p.Token, p.TokenEnv = inv.opts.token, ""
+	}
+	if *tokenVariable != "" {
+		p.Token, p.TokenEnv = "", *tokenVariable
+	}
+	if config.Current == "" {
+		config.Current = name
+	}
+	if err := config.save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(inv.stdout, "Saved profile %s to %s\n", name, config.path)
+	return nil
+}
+
+func profilesUse(inv *invocation, args []string) error {
+	positional, err := inv.parse(inv.flagSet("profiles use"), args, 1)
+	if err != nil {
+		return err
+	}
+	config, err := inv.loadConfig()
+	if err != nil {
+		return err
+	}
+	if _, ok := config.Profiles[positional[0]]; !ok {
+		return fmt.Errorf("no profile %q in %s", positional[0], config.path)
+	}
+	config.Current = positional[0]
+	if err := config.save(); err != nil {
+		return err
+	}
+	fmt.Fprintf(inv.stdout, "Using profile %s\n", positional[0])
+	return nil
+}
+
+// renderMapping : a single mapping, one field per line
+func (inv *invocation) renderMapping(mapping *handlers.Mapping) error {
+	return inv.render(mapping, func(tw *tabwriter.Writer) {
+		row(tw, "Identifier:", mapping.Identifier)
+		row(tw, "Type:", mapping.RouteType)
+		row(tw, "Endpoint:", mapping.PostURL)
+		row(tw, "Description:", mapping.Description)
+		row(tw, "Version:", mapping.Version)
+		if mapping.UpdatedAt != nil {
+			row(tw, "Updated:", mapping.UpdatedAt.Local().Format(time.RFC3339))
+		}
+	})
+}
+
+func (inv *invocation) renderImport(report *handlers.ImportReport) error {
+	return inv.render(report, func(tw *tabwriter.Writer) {
+		row(tw, "IDENTIFIER", "TYPE", "OUTCOME", "ERROR")
+		for _, entry := range report.Entries {
+			row(tw, entry.Identifier, entry.RouteType, entry.Outcome, entry.Error)
+		}
+		row(tw)
+		switch {
+		case report.Applied:
+			row(tw, "Applied in "+report.Mode+" mode")
+		case report.DryRun:
+			row(tw, "Dry run, nothing was applied")
+		default:
+			row(tw, "Nothing was applied")
+		}
+	})
+}
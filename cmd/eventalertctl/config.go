@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// configEnv : overrides the location of the config file
+	configEnv = "EVENTALERTCTL_CONFIG"
+	// serverEnv and tokenEnv : override the server and token of the profile
+	serverEnv = "EVENTALERTCTL_SERVER"
+	tokenEnv  = "EVENTALERTCTL_TOKEN"
+)
+
+// profile : one foundation the client talks to
+type profile struct {
+	Server string `yaml:"server"`
+	// Token is one of the admin_tokens of the server. TokenEnv names an environment variable
+	// holding it instead, to keep it out of the file.
+	Token    string `yaml:"token,omitempty"`
+	TokenEnv string `yaml:"token_env,omitempty"`
+}
+
+// ctlConfig : the config file, profiles by name and the one used by default
+type ctlConfig struct {
+	Current  string              `yaml:"current,omitempty"`
+	Profiles map[string]*profile `yaml:"profiles"`
+
+	path string
+}
+
+// defaultConfigPath : $EVENTALERTCTL_CONFIG, or eventalertctl/config.yml in the user config directory
+func defaultConfigPath() string {
+	if path := os.Getenv(configEnv); path != "" {
+		return path
+	}
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ".eventalertctl.yml"
+	}
+	return filepath.Join(dir, "eventalertctl", "config.yml")
+}
+
+// loadConfig : read path, a missing file is an empty config
+func loadConfig(path string) (*ctlConfig, error) {
+	config := &ctlConfig{Profiles: make(map[string]*profile), path: path}
+	content, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return config, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(content, config); err != nil {
+		return nil, fmt.Errorf("config: could not parse %s: %v", path, err)
+	}
+	if config.Profiles == nil {
+		config.Profiles = make(map[string]*profile)
+	}
+	return config, nil
+}
+
+// save : write the config back, readable by the user only as it may hold tokens
+func (config *ctlConfig) save() error {
+	if err := os.MkdirAll(filepath.Dir(config.path), 0700); err != nil {
+		return err
+	}
+	content, err := yaml.Marshal(config)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(config.path, content, 0600)
+}
+
+func (config *ctlConfig) profileNames() []string {
+	names := make([]string, 0, len(config.Profiles))
+	for name := range config.Profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// resolve : server and token to use. Flags win over the environment, which wins over the profile.
+func (config *ctlConfig) resolve(opts *options) (string, string, error) {
+	name := opts.profile
+	if name == "" {
+		name = config.Current
+	}
+	selected := &profile{}
+	if name != "" {
+		found, ok := config.Profiles[name]
+		if !ok {
+			return "", "", fmt.Errorf("no profile %q in %s", name, config.path)
+		}
+		selected = found
+	}
+
+	server := firstNonEmpty(opts.server, os.Getenv(serverEnv), selected.Server)
+	if server == "" {
+		return "", "", fmt.Errorf("no server given, use --server, %s or a profile", serverEnv)
+	}
+	token := selected.Token
+	if selected.TokenEnv != "" {
+		token = os.Getenv(selected.TokenEnv)
+	}
+	token = firstNonEmpty(opts.token, os.Getenv(tokenEnv), token)
+	return server, token, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,193 @@
+// Command eventalertctl manages the route mappings of pcf-eventalert-integration through its
+// /api/v1 endpoints, for one or several foundations.
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+)
+
+const usage = `eventalertctl manages the route mappings of pcf-eventalert-integration.
+
+Usage:
+  eventalertctl routes list
+  eventalertctl routes get <type> <identifier>
+  eventalertctl routes create <type> <identifier> --url <webhook URL or integration key> [--description <text>]
+  eventalertctl routes delete <type> <identifier>
+  eventalertctl routes export [--file <path>]
+  eventalertctl routes import <file|-> [--mode merge|replace] [--dry-run]
+  eventalertctl preview <type> <identifier> <alert.json|->
+  eventalertctl test <type> <identifier>
+  eventalertctl profiles list
+  eventalertctl profiles set <name> --server <url> [--token <token> | --token-env <variable>]
+  eventalertctl profiles use <name>
+
+<type> is teams or pagerduty.
+
+Flags accepted by every command:
+  --profile <name>    profile of the config file, the current one by default
+  --server <url>      server to call, overrides the profile ($EVENTALERTCTL_SERVER)
+  --token <token>     admin token, overrides the profile ($EVENTALERTCTL_TOKEN)
+  -o, --output <fmt>  table (default), json or yaml
+  --config <path>     config file ($EVENTALERTCTL_CONFIG, default <user config dir>/eventalertctl/config.yml)
+`
+
+// options : the flags every command accepts
+type options struct {
+	profile string
+	server  string
+	token   string
+	output  string
+	config  string
+}
+
+// invocation : one run of the command, with where it reads and writes
+type invocation struct {
+	opts   options
+	stdin  io.Reader
+	stdout io.Writer
+	stderr io.Writer
+}
+
+// usageError : wrong arguments, answered with exit code 2
+type usageError string
+
+func (err usageError) Error() string {
+	return string(err)
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdin io.Reader, stdout io.Writer, stderr io.Writer) int {
+	inv := &invocation{stdin: stdin, stdout: stdout, stderr: stderr}
+	cmd, rest := lookup(args)
+	if cmd == nil {
+		if len(args) > 0 && (args[0] == "help" || args[0] == "-h" || args[0] == "--help") {
+			fmt.Fprint(stdout, usage)
+			return 0
+		}
+		fmt.Fprint(stderr, usage)
+		return 2
+	}
+	err := cmd(inv, rest)
+	var wrongUsage usageError
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, flag.ErrHelp):
+		fmt.Fprint(stdout, usage)
+		return 0
+	case errors.As(err, &wrongUsage):
+		fmt.Fprintf(stderr, "%v\nRun eventalertctl help for usage.\n", err)
+		return 2
+	}
+	fmt.Fprintf(stderr, "error: %v\n", err)
+	return 1
+}
+
+// lookup : the command named by the first arguments, and the arguments left for it
+func lookup(args []string) (func(*invocation, []string) error, []string) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	groups := map[string]map[string]func(*invocation, []string) error{
+		"routes": {
+			"list":   routesList,
+			"get":    routesGet,
+			"create": routesCreate,
+			"delete": routesDelete,
+			"export": routesExport,
+			"import": routesImport,
+		},
+		"profiles": {
+			"list": profilesList,
+			"set":  profilesSet,
+			"use":  profilesUse,
+		},
+	}
+	switch args[0] {
+	case "preview":
+		return preview, args[1:]
+	case "test":
+		return testAlert, args[1:]
+	}
+	if group, ok := groups[args[0]]; ok && len(args) > 1 {
+		if cmd, ok := group[args[1]]; ok {
+			return cmd, args[2:]
+		}
+	}
+	return nil, nil
+}
+
+// flagSet : flags of a command, along with the ones every command accepts
+func (inv *invocation) flagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(ioutil.Discard)
+	fs.StringVar(&inv.opts.profile, "profile", "", "")
+	fs.StringVar(&inv.opts.server, "server", "", "")
+	fs.StringVar(&inv.opts.token, "token", "", "")
+	fs.StringVar(&inv.opts.output, "o", outputTable, "")
+	fs.StringVar(&inv.opts.output, "output", outputTable, "")
+	fs.StringVar(&inv.opts.config, "config", defaultConfigPath(), "")
+	return fs
+}
+
+// parse : parse args, flags being allowed before and after the positional arguments, of which
+// there must be exactly want
+func (inv *invocation) parse(fs *flag.FlagSet, args []string, want int) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return nil, err
+			}
+			return nil, usageError(fs.Name() + ": " + err.Error())
+		}
+		args = fs.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+	if len(positional) != want {
+		return nil, usageError(fmt.Sprintf("%s: expected %d arguments, got %d", fs.Name(), want, len(positional)))
+	}
+	return positional, nil
+}
+
+func (inv *invocation) loadConfig() (*ctlConfig, error) {
+	return loadConfig(inv.opts.config)
+}
+
+// client : a client for the server of the selected profile
+func (inv *invocation) client() (*client, error) {
+	config, err := inv.loadConfig()
+	if err != nil {
+		return nil, err
+	}
+	server, token, err := config.resolve(&inv.opts)
+	if err != nil {
+		return nil, err
+	}
+	return newClient(server, token), nil
+}
+
+func (inv *invocation) render(v interface{}, table func(tw *tabwriter.Writer)) error {
+	return render(inv.stdout, inv.opts.output, v, table)
+}
+
+// readInput : the content of path, or of stdin for -
+func (inv *invocation) readInput(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(inv.stdin)
+	}
+	return ioutil.ReadFile(path)
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+//APIPrefix : path prefix of the versioned JSON API
+const APIPrefix = "/api/v1"
+
+const apiVersionKey contextKey = "api_version"
+
+// Machine-readable error codes of the v1 API
+const (
+	codeInvalidType         = "invalid_type"
+	codeInvalidRequest      = "invalid_request"
+	codeInvalidMapping      = "invalid_mapping"
+	codeMappingNotFound     = "mapping_not_found"
+	codePreconditionFailed  = "precondition_failed"
+	codeVersionConflict     = "version_conflict"
+	codeUnauthorized        = "unauthorized"
+	codeNotAvailable        = "not_available"
+	codeInvalidConfig       = "invalid_config"
+	codeInvalidImport       = "invalid_import"
+	codeRateLimited         = "rate_limited"
+	codeDeliveryFailed      = "delivery_failed"
+	codeDatabaseUnavailable = "database_unavailable"
+	codeShuttingDown        = "shutting_down"
+	codeInternal            = "internal_error"
+)
+
+// errorStatus : HTTP status answered under /api/v1 for each error code. The legacy paths keep
+// the status they always answered, see writeError.
+var errorStatus = map[string]int{
+	codeInvalidType:         http.StatusBadRequest,
+	codeInvalidRequest:      http.StatusBadRequest,
+	codeInvalidMapping:      http.StatusUnprocessableEntity,
+	codeMappingNotFound:     http.StatusNotFound,
+	codePreconditionFailed:  http.StatusPreconditionFailed,
+	codeVersionConflict:     http.StatusConflict,
+	codeUnauthorized:        http.StatusUnauthorized,
+	codeNotAvailable:        http.StatusNotFound,
+	codeInvalidConfig:       http.StatusUnprocessableEntity,
+	codeInvalidImport:       http.StatusUnprocessableEntity,
+	codeRateLimited:         http.StatusTooManyRequests,
+	codeDeliveryFailed:      http.StatusBadGateway,
+	codeDatabaseUnavailable: http.StatusServiceUnavailable,
+	codeShuttingDown:        http.StatusServiceUnavailable,
+	codeInternal:            http.StatusInternalServerError,
+}
+
+// apiError : body of every v1 error, under the error key
+type apiError struct {
+	Code      string      `json:"code"`
+	Message   string      `json:"message"`
+	RequestID string      `json:"requestId,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+}
+
+type errorEnvelope struct {
+	Error apiError `json:"error"`
+}
+
+type dataEnvelope struct {
+	Data interface{} `json:"data"`
+}
+
+//APIv1 : middleware marking requests of the versioned API, their answers are wrapped in envelopes
+func APIv1(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey, "v1")))
+	})
+}
+
+// isAPIv1 : whether the request came in under /api/v1
+func isAPIv1(r *http.Request) bool {
+	version, _ := r.Context().Value(apiVersionKey).(string)
+	return version == "v1"
+}
+
+//Deprecated : wrap a legacy path, advertising its /api/v1 successor. {name} placeholders of
+//successor are filled from the path variables of the request.
+func Deprecated(successor string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		link := APIPrefix + successor
+		for name, value := range mux.Vars(r) {
+			link = strings.Replace(link, "{"+name+"}", value, -1)
+		}
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Link", "<"+link+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// writeError : plain text with legacyStatus on the legacy paths, an error envelope with the
+// status of code under /api/v1
+func writeError(w http.ResponseWriter, r *http.Request, legacyStatus int, code string, message string) {
+	if !isAPIv1(r) {
+		http.Error(w, message, legacyStatus)
+		return
+	}
+	writeAPIError(w, r, apiError{Code: code, Message: message})
+}
+
+func writeAPIError(w http.ResponseWriter, r *http.Request, apiErr apiError) {
+	status, ok := errorStatus[apiErr.Code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	apiErr.RequestID = w.Header().Get(requestIDHeader)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorEnvelope{Error: apiErr})
+}
+
+// writeData : encode v as JSON, wrapped in a data envelope under /api/v1
+func writeData(w http.ResponseWriter, r *http.Request, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if isAPIv1(r) {
+		json.NewEncoder(w).Encode(dataEnvelope{Data: v})
+		return
+	}
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,163 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openAPIVersion : version of the OpenAPI specification the spec follows
+const openAPIVersion = "3.0.3"
+
+var pathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+type jsonObject map[string]interface{}
+
+//OpenAPI : GET request serving the OpenAPI spec of /api/v1. It is built from APIRoutes, the
+//table the routes are registered from, and the schemas are read off the Go types the handlers use.
+func (rh *RequestHandler) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAPISpec(rh.APIRoutes()))
+}
+
+// openAPISpec : the spec document for apiRoutes
+func openAPISpec(apiRoutes []APIRoute) jsonObject {
+	paths := jsonObject{}
+	for _, route := range apiRoutes {
+		item, ok := paths[route.Path].(jsonObject)
+		if !ok {
+			item = jsonObject{}
+			paths[route.Path] = item
+		}
+		item[strings.ToLower(route.Method)] = route.operation()
+	}
+	return jsonObject{
+		"openapi": openAPIVersion,
+		"info": jsonObject{
+			"title":       serviceName,
+			"version":     "v1",
+			"description": "Routes PCF Event Alerts to Microsoft Teams and PagerDuty. Errors carry a machine-readable code.",
+		},
+		"servers": []jsonObject{{"url": APIPrefix}},
+		"paths":   paths,
+		"components": jsonObject{
+			"schemas": jsonObject{"Error": jsonSchema(reflect.TypeOf(errorEnvelope{}))},
+			"securitySchemes": jsonObject{
+				"adminToken": jsonObject{"type": "http", "scheme": "bearer", "description": "one of the admin_tokens of application.yml"},
+			},
+		},
+	}
+}
+
+// operation : the OpenAPI operation object of route
+func (route APIRoute) operation() jsonObject {
+	parameters := []jsonObject{}
+	for _, match := range pathParamPattern.FindAllStringSubmatch(route.Path, -1) {
+		schema := jsonObject{"type": "string"}
+		if match[1] == "type" {
+			schema["enum"] = strings.Split(supportedTypes, ",")
+		}
+		parameters = append(parameters, jsonObject{"name": match[1], "in": "path", "required": true, "schema": schema})
+	}
+	for _, param := range route.query {
+		parameters = append(parameters, jsonObject{"name": param.name, "in": "query", "description": param.description,
+			"schema": jsonObject{"type": "string"}})
+	}
+
+	responses := jsonObject{}
+	for _, status := range route.success {
+		response := jsonObject{"description": http.StatusText(status)}
+		if route.response != nil && status != http.StatusNoContent {
+			response["content"] = jsonContent(jsonObject{
+				"type":       "object",
+				"required":   []string{"data"},
+				"properties": jsonObject{"data": jsonSchema(reflect.TypeOf(route.response))},
+			})
+		}
+		responses[strconv.Itoa(status)] = response
+	}
+	// Codes sharing a status are listed together
+	codes := make(map[int][]string)
+	for _, code := range route.errors {
+		codes[errorStatus[code]] = append(codes[errorStatus[code]], code)
+	}
+	for status, statusCodes := range codes {
+		sort.Strings(statusCodes)
+		responses[strconv.Itoa(status)] = jsonObject{
+			"description": "error code " + strings.Join(statusCodes, ", "),
+			"content":     jsonContent(jsonObject{"$ref": "#/components/schemas/Error"}),
+		}
+	}
+
+	operation := jsonObject{
+		"operationId": route.name,
+		"summary":     route.summary,
+		"parameters":  parameters,
+		"responses":   responses,
+	}
+	if route.body != nil {
+		operation["requestBody"] = jsonObject{"required": true, "content": jsonContent(jsonSchema(reflect.TypeOf(route.body)))}
+	}
+	if route.admin {
+		operation["security"] = []jsonObject{{"adminToken": []string{}}}
+	}
+	if route.Legacy != "" {
+		operation["x-deprecated-alias"] = route.Legacy
+	}
+	return operation
+}
+
+func jsonContent(schema jsonObject) jsonObject {
+	return jsonObject{"application/json": jsonObject{"schema": schema}}
+}
+
+// jsonSchema : schema of what encoding/json makes of t
+func jsonSchema(t reflect.Type) jsonObject {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return jsonObject{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(json.RawMessage{}):
+		return jsonObject{"type": "object"}
+	}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return jsonSchema(t.Elem())
+	case reflect.String:
+		return jsonObject{"type": "string"}
+	case reflect.Bool:
+		return jsonObject{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return jsonObject{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return jsonObject{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return jsonObject{"type": "array", "items": jsonSchema(t.Elem())}
+	case reflect.Map:
+		return jsonObject{"type": "object", "additionalProperties": jsonSchema(t.Elem())}
+	case reflect.Struct:
+		properties := jsonObject{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if field.PkgPath != "" {
+				continue
+			}
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "-" {
+				continue
+			}
+			if name == "" {
+				name = field.Name
+			}
+			properties[name] = jsonSchema(field.Type)
+		}
+		return jsonObject{"type": "object", "properties": properties}
+	}
+	// interface{} holds anything
+	return jsonObject{}
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/tushardag/pcf-eventalert-integration/helpers"
+)
+
+//APIRoute : an endpoint of the v1 API, registered under APIPrefix. Legacy is the deprecated
+//path serving the same handler, empty when there is none. The OpenAPI spec is built from the rest.
+type APIRoute struct {
+	Method  string
+	Path    string
+	Legacy  string
+	Handler http.HandlerFunc
+
+	name    string
+	summary string
+	admin   bool
+	query   []apiParam
+	// body and response are zero values of what is decoded and encoded, nil when there is none
+	body     interface{}
+	response interface{}
+	success  []int
+	errors   []string
+}
+
+// apiParam : a query parameter
+type apiParam struct {
+	name        string
+	description string
+}
+
+//APIRoutes : every endpoint of the v1 API. The order matters for the legacy paths, the fixed
+//two-segment ones come before /{type}/{identifier} which would otherwise shadow them.
+func (rh *RequestHandler) APIRoutes() []APIRoute {
+	mappingPath := "/mappings/{type}/{identifier}"
+	apiRoutes := []APIRoute{
+		{
+			name: "listMappings", summary: "List every mapping",
+			Method: "GET", Path: "/mappings", Legacy: "/routes", Handler: rh.ListMappings,
+			response: []*routes{}, success: []int{http.StatusOK},
+			errors: []string{codeInternal},
+		},
+		{
+			name: "exportMappings", summary: "Export every mapping in the layout of the notifications block, JSON or YAML with format=yaml",
+			Method: "GET", Path: "/mappings/export", Legacy: "/routes/export", Handler: rh.ExportMappings, admin: true,
+			query:    []apiParam{{"format", "json (default under /api/v1) or yaml"}},
+			response: routeExport{}, success: []int{http.StatusOK},
+			errors: []string{codeInternal},
+		},
+		{
+			name: "configStatus", summary: "Hash and load time of the active config",
+			Method: "GET", Path: "/admin/status", Legacy: "/admin/status", Handler: rh.ConfigStatus, admin: true,
+			response: configStatus{}, success: []int{http.StatusOK},
+		},
+		{
+			name: "cacheStatus", summary: "Size, age and hit rate of the mapping cache",
+			Method: "GET", Path: "/admin/cache", Legacy: "/admin/cache", Handler: rh.CacheStatus, admin: true,
+			response: cacheStats{}, success: []int{http.StatusOK},
+			errors: []string{codeNotAvailable},
+		},
+		{
+			name: "reloadConfig", summary: "Reload application.yml, keeping the active config when it is invalid",
+			Method: "POST", Path: "/admin/reload", Legacy: "/admin/reload", Handler: rh.ReloadConfigRequest, admin: true,
+			response: configStatus{}, success: []int{http.StatusOK},
+			errors: []string{codeInvalidConfig},
+		},
+		{
+			name: "importMappings", summary: "Validate and apply an export in one transaction, JSON or YAML",
+			Method: "POST", Path: "/mappings/import", Legacy: "/routes/import", Handler: rh.ImportMappings, admin: true,
+			query: []apiParam{
+				{"mode", "merge (default) keeps mappings missing from the body, replace removes them"},
+				{"dry_run", "true only reports what would change"},
+			},
+			body: routeExport{}, response: importReport{}, success: []int{http.StatusOK},
+			errors: []string{codeInvalidRequest, codeInvalidImport, codeDatabaseUnavailable, codeInternal},
+		},
+	}
+	// Schema migrations only exist for the SQL storage modes
+	if rh.DBinUse() {
+		apiRoutes = append(apiRoutes, APIRoute{
+			name: "schemaStatus", summary: "Schema version of the mapping table and pending migrations",
+			Method: "GET", Path: "/admin/schema", Legacy: "/admin/schema", Handler: rh.SchemaStatus, admin: true,
+			response: schemaStatus{}, success: []int{http.StatusOK},
+			errors: []string{codeNotAvailable, codeInternal},
+		})
+	}
+	auditQuery := []apiParam{
+		{"since", "RFC 3339 timestamp, e.g. 2019-07-01T00:00:00Z"},
+		{"until", "RFC 3339 timestamp"},
+		{"limit", "maximum number of records"},
+	}
+	apiRoutes = append(apiRoutes,
+		APIRoute{
+			name: "listAudit", summary: "Audit trail of all mappings",
+			Method: "GET", Path: "/audit", Legacy: "/audit", Handler: rh.ListAudit, admin: true,
+			query: auditQuery, response: []*auditRecord{}, success: []int{http.StatusOK},
+			errors: []string{codeInvalidRequest, codeInternal},
+		},
+		APIRoute{
+			name: "recentEvents", summary: "Last alerts handled by this instance, newest first",
+			Method: "GET", Path: "/events", Handler: rh.RecentEvents, admin: true,
+			query: []apiParam{
+				{"outcome", "delivered, failed, unmapped, invalid, rejected or spooled"},
+				{"limit", "maximum number of events, 50 by default"},
+			},
+			response: []recentEvent{}, success: []int{http.StatusOK},
+			errors: []string{codeInvalidRequest},
+		},
+		APIRoute{
+			name: "mappingHistory", summary: "Audit trail of a single mapping",
+			Method: "GET", Path: mappingPath + "/history", Legacy: "/routes/{type}/{identifier}/history", Handler: rh.MappingHistory, admin: true,
+			query: auditQuery, response: []*auditRecord{}, success: []int{http.StatusOK},
+			errors: []string{codeInvalidType, codeInvalidRequest, codeInternal},
+		},
+		APIRoute{
+			name: "saveMapping", summary: "Create or replace a mapping, If-Match guards a replace",
+			Method: "PUT", Path: mappingPath, Legacy: "/{type}/{identifier}", Handler: rh.CreatMapping, admin: true,
+			body: requestJSON{}, response: routes{}, success: []int{http.StatusOK, http.StatusCreated},
+			errors: []string{codeInvalidType, codeInvalidRequest, codeInvalidMapping, codePreconditionFailed,
+				codeVersionConflict, codeDatabaseUnavailable, codeInternal},
+		},
+		APIRoute{
+			name: "patchMapping", summary: "Update some fields of a mapping, If-Match guards the update",
+			Method: "PATCH", Path: mappingPath, Legacy: "/{type}/{identifier}", Handler: rh.PatchMapping, admin: true,
+			body: patchJSON{}, response: routes{}, success: []int{http.StatusOK},
+			errors: []string{codeInvalidType, codeInvalidRequest, codeInvalidMapping, codeMappingNotFound,
+				codeVersionConflict, codeDatabaseUnavailable, codeInternal},
+		},
+		APIRoute{
+			name: "deleteMapping", summary: "Remove a mapping",
+			Method: "DELETE", Path: mappingPath, Legacy: "/{type}/{identifier}", Handler: rh.RemoveMapping, admin: true,
+			success: []int{http.StatusNoContent},
+			errors:  []string{codeInvalidType, codeMappingNotFound, codeDatabaseUnavailable, codeInternal},
+		},
+		APIRoute{
+			name: "getMapping", summary: "A single mapping, its version is the ETag",
+			Method: "GET", Path: mappingPath, Legacy: "/{type}/{identifier}", Handler: rh.GetMapping,
+			response: routes{}, success: []int{http.StatusOK},
+			errors: []string{codeMappingNotFound},
+		},
+		APIRoute{
+			name: "teamsAlert", summary: "Deliver an Event Alert to the Teams webhook of the mapping",
+			Method: "POST", Path: "/alerts/teams/{identifier}", Legacy: "/teams/{identifier}", Handler: rh.MSTeamsAlert,
+			body: helpers.EventAlert{}, response: alertDelivery{}, success: []int{http.StatusOK, http.StatusAccepted},
+			errors: []string{codeShuttingDown, codeMappingNotFound, codeInvalidRequest, codeDeliveryFailed},
+		},
+		APIRoute{
+			name: "pagerdutyAlert", summary: "Open a PagerDuty incident for an Event Alert",
+			Method: "POST", Path: "/alerts/pagerduty/{identifier}", Legacy: "/pagerduty/{identifier}", Handler: rh.PagerDutyAlert,
+			body: helpers.EventAlert{}, response: alertDelivery{}, success: []int{http.StatusOK, http.StatusAccepted},
+			errors: []string{codeShuttingDown, codeMappingNotFound, codeInvalidRequest, codeDeliveryFailed},
+		},
+		APIRoute{
+			name: "previewAlert", summary: "Render what an Event Alert would send, without sending it",
+			Method: "POST", Path: "/preview/{type}/{identifier}", Legacy: "/preview/{type}/{identifier}", Handler: rh.PreviewAlert,
+			body: helpers.EventAlert{}, response: alertPreview{}, success: []int{http.StatusOK},
+			errors: []string{codeInvalidType, codeInvalidRequest, codeMappingNotFound, codeInternal},
+		},
+		// After the preview route, which the legacy path would otherwise shadow
+		APIRoute{
+			name: "testMapping", summary: "Send a labelled synthetic alert through a mapping, once a minute at most",
+			Method: "POST", Path: mappingPath + "/test", Legacy: "/{type}/{identifier}/test", Handler: rh.TestAlert, admin: true,
+			response: testAlertResult{}, success: []int{http.StatusOK},
+			errors: []string{codeInvalidType, codeMappingNotFound, codeRateLimited, codeDeliveryFailed},
+		},
+	)
+	for i := range apiRoutes {
+		if apiRoutes[i].admin {
+			apiRoutes[i].Handler = rh.RequireAdmin(apiRoutes[i].Handler)
+			apiRoutes[i].errors = append(apiRoutes[i].errors, codeUnauthorized)
+		}
+	}
+	return apiRoutes
+}
@@ -0,0 +1,35 @@
+package handlers
+
+// Request and response bodies of the v1 API, exported for clients such as cmd/eventalertctl so
+// that they decode exactly what the handlers encode.
+type (
+	//Mapping : a route mapping, as listed and returned by the mapping endpoints
+	Mapping = routes
+	//MappingRequest : body of PUT /mappings/{type}/{identifier}
+	MappingRequest = requestJSON
+	//MappingPatch : body of PATCH /mappings/{type}/{identifier}, absent fields are left untouched
+	MappingPatch = patchJSON
+	//MappingExport : body of the export and import endpoints
+	MappingExport = routeExport
+	//ImportReport : per-entry outcome of an import
+	ImportReport = importReport
+	//AlertPreview : what an alert would turn into, answered by the preview endpoint
+	AlertPreview = alertPreview
+	//TestAlertResult : what Teams or PagerDuty answered to a test alert
+	TestAlertResult = testAlertResult
+	//AuditRecord : one change of a mapping
+	AuditRecord = auditRecord
+	//RecentEvent : one alert handled by an instance
+	RecentEvent = recentEvent
+	//AlertDelivery : answer to a delivered alert
+	AlertDelivery = alertDelivery
+	//APIError : body of every v1 error, under the error key of ErrorEnvelope
+	APIError = apiError
+	//ErrorEnvelope : what a v1 error is wrapped in
+	ErrorEnvelope = errorEnvelope
+)
+
+//MaskSecret : a webhook URL or routing key with all but its host and last characters hidden
+func MaskSecret(secret string) string {
+	return maskSecret(secret)
+}
@@ -1,62 +1,240 @@
 package handlers
 
-import "fmt"
+import (
+	"bytes"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// notification : Teams and PagerDuty destinations of one identifier, as listed under notifications
+type notification struct {
+	Name        string `yaml:"name" json:"name"`
+	Teams       string `yaml:"teams,omitempty" json:"teams,omitempty"`
+	Pagerduty   string `yaml:"pagerduty,omitempty" json:"pagerduty,omitempty"`
+	Description string `yaml:"description,omitempty" json:"description,omitempty"`
+	// TeamsDescription and PagerdutyDescription override Description for one destination, an
+	// export writes them when the two mappings are described differently.
+	TeamsDescription     string `yaml:"teams_description,omitempty" json:"teamsDescription,omitempty"`
+	PagerdutyDescription string `yaml:"pagerduty_description,omitempty" json:"pagerdutyDescription,omitempty"`
+}
+
+const (
+	storageMemory   = "memory"
+	storageConfig   = "config"
+	storageMySQL    = "mysql"
+	storagePostgres = "postgres"
+	storageSQLite   = "sqlite"
+
+	storageModes = "memory, config, mysql, postgres, sqlite"
+)
 
 type applicationConfig struct {
-	EnableMysql   bool `yaml:"enable_mysql"`
-	Notifications []struct {
-		Name      string `yaml:"name"`
-		Teams     string `yaml:"teams"`
-		Pagerduty string `yaml:"pagerduty"`
-	} `yaml:"notifications"`
+	EnableMysql bool `yaml:"enable_mysql"`
+	// StorageMode is one of storageModes, config being an alias of memory. When empty,
+	// enable_mysql picks between memory and the engine of the bound database service.
+	StorageMode string `yaml:"storage_mode"`
+	// DatabaseService picks the bound database service by name, label or tag. Optional,
+	// the first MySQL or PostgreSQL service is used otherwise.
+	DatabaseService string `yaml:"database_service"`
+	// Database tunes the mysql and postgres storage modes. Optional.
+	Database databaseSettings `yaml:"database"`
+	// SQLitePath is the database file used by the sqlite storage mode.
+	SQLitePath string `yaml:"sqlite_path"`
+	// MappingsFile persists changes made in memory storage mode. Optional.
+	MappingsFile  string         `yaml:"mappings_file"`
+	Notifications []notification `yaml:"notifications"`
+	// AdminTokens authenticate the mapping management endpoints. Open to all when empty.
+	AdminTokens []adminToken     `yaml:"admin_tokens"`
+	Metrics     metricsSettings  `yaml:"metrics"`
+	Health      healthSettings   `yaml:"health"`
+	Logging     loggingSettings  `yaml:"logging"`
+	Tracing     tracingSettings  `yaml:"tracing"`
+	Shutdown    shutdownSettings `yaml:"shutdown"`
+}
+
+// shutdownSettings : how long a shutdown waits for deliveries, and where those left over go
+type shutdownSettings struct {
+	// DrainTimeout bounds the wait for requests and deliveries in flight, 8s by default.
+	DrainTimeout time.Duration `yaml:"drain_timeout"`
+	// SpoolFile receives the deliveries still in flight after DrainTimeout, replayed on the
+	// next start. Optional, they are only logged otherwise.
+	SpoolFile string `yaml:"spool_file"`
+}
+
+// tracingSettings : where OpenTelemetry spans are exported, only read on startup
+type tracingSettings struct {
+	// Exporter is none, stdout or otlp, none by default.
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the OTLP/HTTP collector URL. Optional, OTEL_EXPORTER_OTLP_ENDPOINT applies otherwise.
+	Endpoint string `yaml:"endpoint"`
+	// Propagate lists the destinations, teams or pagerduty, receiving the trace context in
+	// traceparent. None by default, so that trace IDs stay internal.
+	Propagate []string `yaml:"propagate"`
+}
+
+// loggingSettings : level and line format of the application log, applied again on reload
+type loggingSettings struct {
+	// Level is debug, info, warn or error, info by default.
+	Level string `yaml:"level"`
+	// Format is json or text, json by default.
+	Format string `yaml:"format"`
+}
+
+// healthSettings : optional checks reported on the readiness endpoint
+type healthSettings struct {
+	// CheckDestinations probes the Teams and PagerDuty hosts every DestinationInterval, 5m by default.
+	CheckDestinations   bool          `yaml:"check_destinations"`
+	DestinationInterval time.Duration `yaml:"destination_interval"`
+}
+
+// metricsSettings : bounds on the label values of /metrics
+type metricsSettings struct {
+	// MaxIdentifiers is how many identifiers get a series of their own, the rest are counted
+	// as other. 50 when zero.
+	MaxIdentifiers int `yaml:"max_identifiers"`
+}
+
+// databaseSettings : schema naming and connection pool of the mysql and postgres storage modes
+type databaseSettings struct {
+	// Name of the MySQL schema or PostgreSQL database. MySQL defaults to event_router_mapping,
+	// PostgreSQL to the database of the bound service.
+	Name string `yaml:"name"`
+	// TablePrefix is prepended to every table name, for schemas shared with other apps.
+	TablePrefix     string        `yaml:"table_prefix"`
+	MaxOpenConns    int           `yaml:"max_open_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	ConnMaxLifetime time.Duration `yaml:"conn_max_lifetime"`
+	// StartupTimeout bounds the connection retries on startup, 45s by default.
+	StartupTimeout time.Duration `yaml:"startup_timeout"`
+	// CacheTTL is the longest cached mappings are used, 5m by default. CachePollInterval is how
+	// often changes made by other instances are looked for, 10s by default.
+	CacheTTL          time.Duration `yaml:"cache_ttl"`
+	CachePollInterval time.Duration `yaml:"cache_poll_interval"`
+}
+
+// apply : copy the settings onto the connection settings of the engine config.Driver
+func (settings databaseSettings) apply(config DBConfig) DBConfig {
+	if settings.Name != "" {
+		if config.Driver == storagePostgres {
+			config.Database = settings.Name
+		} else {
+			config.Schema = settings.Name
+		}
+	}
+	config.TablePrefix = settings.TablePrefix
+	config.MaxOpenConns = settings.MaxOpenConns
+	config.MaxIdleConns = settings.MaxIdleConns
+	config.ConnMaxLifetime = settings.ConnMaxLifetime
+	return config
 }
 
-func (applConfig *applicationConfig) listRoutes() ([]*routes, error) {
+// adminToken : bearer token of one admin, the name is recorded in the audit trail
+type adminToken struct {
+	Name  string `yaml:"name"`
+	Token string `yaml:"token"`
+}
+
+// storageMode : where the mappings live. detected is the engine of the bound database service, if any.
+func (applConfig *applicationConfig) storageMode(detected string) string {
+	if applConfig.StorageMode != "" {
+		return applConfig.StorageMode
+	}
+	if !applConfig.EnableMysql {
+		return storageMemory
+	}
+	if detected != "" {
+		return detected
+	}
+	return storageMySQL
+}
+
+// routes : expand a notifications entry into its route mappings, one per configured destination
+func (notify notification) routes() []*routes {
 	var routeEntries []*routes
-	for _, notify := range applConfig.Notifications {
-		route := &routes{
+	if notify.Teams != "" {
+		routeEntries = append(routeEntries, &routes{
 			Identifier:  notify.Name,
-			RouteType:   "teams",
+			RouteType:   teamsType,
 			PostURL:     notify.Teams,
-			Description: notify.Name,
-		}
-		routeEntries = append(routeEntries, route)
-		route = &routes{
+			Description: notify.description(notify.TeamsDescription),
+		})
+	}
+	if notify.Pagerduty != "" {
+		routeEntries = append(routeEntries, &routes{
 			Identifier:  notify.Name,
-			RouteType:   "pagerduty",
+			RouteType:   pagerdutyType,
 			PostURL:     notify.Pagerduty,
-			Description: notify.Name,
-		}
-		routeEntries = append(routeEntries, route)
+			Description: notify.description(notify.PagerdutyDescription),
+		})
 	}
-	if routeEntries == nil {
-		return nil, fmt.Errorf("unable to parse the entries from application configs %v", nil)
+	return routeEntries
+}
+
+// description : the description of one destination, falling back to the shared one and the name
+func (notify notification) description(destination string) string {
+	if destination != "" {
+		return destination
+	}
+	if notify.Description != "" {
+		return notify.Description
 	}
-	return routeEntries, nil
+	return notify.Name
 }
 
-func (applConfig *applicationConfig) getRoute(identifier string, routeType string) (*routes, error) {
-	var route *routes
-	for _, notify := range applConfig.Notifications {
-		if notify.Name == identifier && routeType == "pagerduty" {
-			route = &routes{
-				Identifier:  notify.Name,
-				RouteType:   "pagerduty",
-				PostURL:     notify.Pagerduty,
-				Description: notify.Name,
-			}
+// notificationsFromRoutes : fold route mappings back into the notifications block layout
+func notificationsFromRoutes(routeEntries []*routes) []notification {
+	var notifications []notification
+	index := make(map[string]int)
+	for _, rt := range routeEntries {
+		i, found := index[rt.Identifier]
+		if !found {
+			i = len(notifications)
+			index[rt.Identifier] = i
+			notifications = append(notifications, notification{Name: rt.Identifier})
+		}
+		description := rt.Description
+		if description == rt.Identifier {
+			description = ""
 		}
-		if notify.Name == identifier && routeType == "teams" {
-			route = &routes{
-				Identifier:  notify.Name,
-				RouteType:   "teams",
-				PostURL:     notify.Teams,
-				Description: notify.Name,
-			}
+		switch rt.RouteType {
+		case teamsType:
+			notifications[i].Teams = rt.PostURL
+			notifications[i].TeamsDescription = description
+		case pagerdutyType:
+			notifications[i].Pagerduty = rt.PostURL
+			notifications[i].PagerdutyDescription = description
 		}
 	}
-	if route == nil {
-		return nil, fmt.Errorf("unable to find entry for %s with type %s", identifier, routeType)
+	for i := range notifications {
+		notifications[i].foldDescriptions()
+	}
+	return notifications
+}
+
+// foldDescriptions : write a description shared by the destinations once, keeping the ones
+// per destination only when they differ
+func (notify *notification) foldDescriptions() {
+	switch {
+	case notify.Teams == "":
+		notify.Description, notify.PagerdutyDescription = notify.PagerdutyDescription, ""
+	case notify.Pagerduty == "":
+		notify.Description, notify.TeamsDescription = notify.TeamsDescription, ""
+	case notify.TeamsDescription == notify.PagerdutyDescription:
+		notify.Description, notify.TeamsDescription, notify.PagerdutyDescription = notify.TeamsDescription, "", ""
+	}
+}
+
+// marshalYAML : encode with the two space indent used by application.yml
+func marshalYAML(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
 	}
-	return route, nil
+	return buf.Bytes(), nil
 }
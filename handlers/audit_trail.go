@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	auditCreate = "create"
+	auditUpdate = "update"
+	auditDelete = "delete"
+	auditTest   = "test"
+
+	defaultAuditLimit = 100
+	maxAuditLimit     = 1000
+)
+
+// auditRecord : one change made to a route mapping through the management API
+type auditRecord struct {
+	ID         int64        `json:"id"`
+	Identifier string       `json:"identifier"`
+	RouteType  string       `json:"routeType"`
+	Action     string       `json:"action"`
+	Actor      string       `json:"actor"`
+	SourceIP   string       `json:"sourceIP"`
+	ChangedAt  time.Time    `json:"changedAt"`
+	Before     *auditValues `json:"before,omitempty"`
+	After      *auditValues `json:"after,omitempty"`
+}
+
+// auditValues : snapshot of a mapping with its secret masked
+type auditValues struct {
+	PostURL     string `json:"postURL"`
+	Description string `json:"description"`
+	Version     int64  `json:"version,omitempty"`
+	// Test holds what Teams or PagerDuty answered to a test alert, without the response bodies
+	Test []testStep `json:"test,omitempty"`
+}
+
+// auditFilter : narrows down the audit records returned by listAudit
+type auditFilter struct {
+	Identifier string
+	RouteType  string
+	Since      time.Time
+	Until      time.Time
+	Limit      int
+}
+
+func newAuditValues(route *routes) *auditValues {
+	if route == nil {
+		return nil
+	}
+	return &auditValues{
+		PostURL:     maskSecret(route.PostURL),
+		Description: route.Description,
+		Version:     route.Version,
+	}
+}
+
+// maskSecret : hide webhook paths and routing keys, keeping enough to tell them apart.
+// Teams URLs keep their scheme and host, everything keeps its last 4 characters.
+func maskSecret(secret string) string {
+	var prefix string
+	if u, err := url.Parse(secret); err == nil && u.Scheme != "" && u.Host != "" {
+		prefix = u.Scheme + "://" + u.Host + "/"
+		secret = strings.TrimPrefix(secret, prefix)
+	}
+	if len(secret) <= 4 {
+		return prefix + strings.Repeat("*", len(secret))
+	}
+	return prefix + "****" + secret[len(secret)-4:]
+}
@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/tushardag/pcf-eventalert-integration/helpers"
+	"gopkg.in/yaml.v3"
+)
+
+// referencePattern : ${ENV_VAR} or ${vcap:service.credentials.key}, $${...} is kept as a literal ${...}
+var referencePattern = regexp.MustCompile(`\$?\$\{([^}]*)\}`)
+
+// vcapPrefix : marks a reference to the credentials of a bound service
+const vcapPrefix = "vcap:"
+
+// envNamePattern : what a ${ENV_VAR} reference may name
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// configResolver : resolves references in config values, VCAP_SERVICES is only parsed when first needed
+type configResolver struct {
+	services helpers.VCAPServices
+	lookup   func(string) (string, bool)
+	// checkOnly leaves the references in place once their syntax is checked, for a config
+	// validated away from the platform it is pushed to
+	checkOnly bool
+	// unresolved : line of every value still holding a reference afterwards, by field
+	unresolved map[string]int
+}
+
+// interpolateConfig : replace the references in every scalar value under doc, collecting the ones
+// that cannot be resolved. The fields left holding a reference are returned with their line.
+func interpolateConfig(doc *yaml.Node, checkOnly bool) (configErrors, map[string]int) {
+	var errs configErrors
+	resolver := &configResolver{lookup: os.LookupEnv, checkOnly: checkOnly, unresolved: make(map[string]int)}
+	resolver.walk(doc, "", &errs)
+	return errs, resolver.unresolved
+}
+
+func (r *configResolver) walk(node *yaml.Node, field string, errs *configErrors) {
+	switch node.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			name := node.Content[i].Value
+			if field != "" {
+				name = field + "." + name
+			}
+			r.walk(node.Content[i+1], name, errs)
+		}
+	case yaml.SequenceNode:
+		for i, item := range node.Content {
+			r.walk(item, fmt.Sprintf("%s[%d]", field, i), errs)
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "${") {
+			return
+		}
+		held := false
+		node.Value = referencePattern.ReplaceAllStringFunc(node.Value, func(match string) string {
+			if strings.HasPrefix(match, "$$") {
+				return match[1:]
+			}
+			reference := match[2 : len(match)-1]
+			err := checkReference(reference)
+			if err == nil && r.checkOnly {
+				held = true
+				return match
+			}
+			var value string
+			if err == nil {
+				value, err = r.resolve(reference)
+			}
+			if err != nil {
+				errs.add(node, field, "%s: %v", match, err)
+				held = true
+				return match
+			}
+			return value
+		})
+		if held {
+			r.unresolved[field] = node.Line
+		}
+		// Unquoted values are typed by what they resolve to, so ${ENABLE_MYSQL} can hold a bool
+		if node.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle|yaml.LiteralStyle|yaml.FoldedStyle) == 0 {
+			node.Tag = ""
+		}
+	}
+}
+
+// checkReference : whether a reference, without the surrounding ${}, is well formed
+func checkReference(reference string) error {
+	if !strings.HasPrefix(reference, vcapPrefix) {
+		if !envNamePattern.MatchString(reference) {
+			return fmt.Errorf("expected an environment variable name or the form vcap:service-name.credentials.key")
+		}
+		return nil
+	}
+	parts := strings.SplitN(strings.TrimPrefix(reference, vcapPrefix), ".credentials.", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return fmt.Errorf("expected the form vcap:service-name.credentials.key")
+	}
+	return nil
+}
+
+// resolve : the value of one well formed reference, without the surrounding ${}
+func (r *configResolver) resolve(reference string) (string, error) {
+	if !strings.HasPrefix(reference, vcapPrefix) {
+		value, found := r.lookup(reference)
+		if !found {
+			return "", fmt.Errorf("environment variable %s is not set", reference)
+		}
+		return value, nil
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(reference, vcapPrefix), ".credentials.", 2)
+	if r.services == nil {
+		services, err := helpers.ParseVCAPServices()
+		if err != nil {
+			return "", err
+		}
+		r.services = services
+	}
+	binding, found := r.services.Binding(parts[0])
+	if !found {
+		return "", fmt.Errorf("service %s is not bound to this app", parts[0])
+	}
+	return binding.Credential(parts[1])
+}
@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"os"
+	"reflect"
+	"time"
+)
+
+// reloadActor : recorded in the audit trail for mappings changed by a config reload
+const reloadActor = "config-reload"
+
+// configState : an application.yml as loaded, swapped as a whole on reload
+type configState struct {
+	config   *applicationConfig
+	hash     string
+	loadedAt time.Time
+}
+
+// configStatus : what the status endpoint reports about the active config
+type configStatus struct {
+	ConfigFile      string    `json:"configFile,omitempty"`
+	ConfigHash      string    `json:"configHash"`
+	LoadedAt        time.Time `json:"loadedAt"`
+	StorageMode     string    `json:"storageMode"`
+	LastReloadError string    `json:"lastReloadError,omitempty"`
+}
+
+func newConfigState(applConfig *applicationConfig, yamlFile []byte) *configState {
+	sum := sha256.Sum256(yamlFile)
+	return &configState{config: applConfig, hash: hex.EncodeToString(sum[:]), loadedAt: time.Now().UTC()}
+}
+
+// applConfig : the active application config. Hold on to the result for the length of a request.
+func (rh *RequestHandler) applConfig() *applicationConfig {
+	return rh.config.Load().config
+}
+
+//WatchConfig : reload the config file whenever its modification time changes
+func (rh *RequestHandler) WatchConfig(path string, interval time.Duration) {
+	rh.configPath = path
+	go func() {
+		var lastModified time.Time
+		if info, err := os.Stat(path); err == nil {
+			lastModified = info.ModTime()
+		}
+		for range time.Tick(interval) {
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(lastModified) {
+				continue
+			}
+			lastModified = info.ModTime()
+			slog.Info("Detected a change to the config file", "path", path)
+			rh.ReloadConfig()
+		}
+	}()
+}
+
+//ReloadConfig : read the config file again and swap it in when valid. The active
+//config stays in place when the new one fails validation.
+func (rh *RequestHandler) ReloadConfig() error {
+	rh.reloadMu.Lock()
+	defer rh.reloadMu.Unlock()
+
+	err := rh.reloadConfig()
+	if err != nil {
+		slog.Error("Keeping the active config, reload failed", "path", rh.configPath, "error", err)
+		message := err.Error()
+		rh.lastReloadError.Store(&message)
+		return err
+	}
+	rh.lastReloadError.Store(nil)
+	return nil
+}
+
+func (rh *RequestHandler) reloadConfig() error {
+	if rh.configPath == "" {
+		return fmt.Errorf("no config file to reload from")
+	}
+	yamlFile, err := ioutil.ReadFile(rh.configPath)
+	if err != nil {
+		return err
+	}
+	active := rh.config.Load()
+	next := newConfigState(nil, yamlFile)
+	if next.hash == active.hash {
+		return nil
+	}
+	if next.config, err = parseApplicationConfig(yamlFile); err != nil {
+		return err
+	}
+	if err := active.config.sameStorage(next.config); err != nil {
+		return err
+	}
+	// Propagation is read per delivery, the exporter is set up once
+	if active.config.Tracing.Exporter != next.config.Tracing.Exporter || active.config.Tracing.Endpoint != next.config.Tracing.Endpoint {
+		return fmt.Errorf("tracing settings changed, restart the app to apply them")
+	}
+
+	// Without a mappings file the notifications block is the source of the in-memory mappings.
+	var changes []reseedChange
+	mem, ok := rh.dbConn.(*memoryDB)
+	if ok && next.config.MappingsFile == "" && !reflect.DeepEqual(active.config.Notifications, next.config.Notifications) {
+		if changes, err = mem.reseed(active.config.Notifications, next.config.Notifications); err != nil {
+			return err
+		}
+	}
+	if err := next.config.Logging.apply(); err != nil {
+		return err
+	}
+	rh.config.Store(next)
+	rh.auditReload(changes)
+	slog.Info("Reloaded the config", "path", rh.configPath, "hash", next.hash)
+	return nil
+}
+
+// auditReload : record the mappings changed by a reload of the notifications block
+func (rh *RequestHandler) auditReload(changes []reseedChange) {
+	for _, change := range changes {
+		subject, action := change.after, auditUpdate
+		switch {
+		case change.before == nil:
+			action = auditCreate
+		case change.after == nil:
+			subject, action = change.before, auditDelete
+		}
+		rec := &auditRecord{
+			Identifier: subject.Identifier,
+			RouteType:  subject.RouteType,
+			Action:     action,
+			Actor:      reloadActor,
+			ChangedAt:  time.Now().UTC(),
+			Before:     newAuditValues(change.before),
+			After:      newAuditValues(change.after),
+		}
+		if err := rh.dbConn.recordAudit(rec); err != nil {
+			slog.Error("Unable to record audit entry", "route_type", rec.RouteType, "identifier", rec.Identifier, "error", err)
+		}
+	}
+}
+
+// sameStorage : storage settings only take effect on restart, refuse a reload changing them
+func (applConfig *applicationConfig) sameStorage(next *applicationConfig) error {
+	if applConfig.EnableMysql != next.EnableMysql || applConfig.StorageMode != next.StorageMode ||
+		applConfig.SQLitePath != next.SQLitePath || applConfig.MappingsFile != next.MappingsFile ||
+		applConfig.DatabaseService != next.DatabaseService || applConfig.Database != next.Database {
+		return fmt.Errorf("storage settings changed, restart the app to apply them")
+	}
+	return nil
+}
+
+//ConfigStatus : report the hash and load time of the active config
+func (rh *RequestHandler) ConfigStatus(w http.ResponseWriter, r *http.Request) {
+	writeData(w, r, http.StatusOK, rh.configStatus())
+}
+
+//ReloadConfigRequest : POST request to reload the config file
+func (rh *RequestHandler) ReloadConfigRequest(w http.ResponseWriter, r *http.Request) {
+	if err := rh.ReloadConfig(); err != nil {
+		writeError(w, r, http.StatusUnprocessableEntity, codeInvalidConfig, "Config reload failed, keeping the active config: "+err.Error())
+		return
+	}
+	writeData(w, r, http.StatusOK, rh.configStatus())
+}
+
+func (rh *RequestHandler) configStatus() configStatus {
+	state := rh.config.Load()
+	status := configStatus{
+		ConfigFile:  rh.configPath,
+		ConfigHash:  state.hash,
+		LoadedAt:    state.loadedAt,
+		StorageMode: rh.storageMode,
+	}
+	if message := rh.lastReloadError.Load(); message != nil {
+		status.LastReloadError = *message
+	}
+	return status
+}
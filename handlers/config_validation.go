@@ -0,0 +1,333 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/tushardag/pcf-eventalert-integration/helpers"
+	"gopkg.in/yaml.v3"
+)
+
+// tablePrefixPattern : prefixes end up unquoted in SQL statements
+var tablePrefixPattern = regexp.MustCompile(`^[A-Za-z0-9_]*$`)
+
+// routingKeyPattern : PagerDuty Events API v2 integration keys are 32 alphanumeric characters
+var routingKeyPattern = regexp.MustCompile(`^[A-Za-z0-9]{32}$`)
+
+// configError : one problem found in application.yml, with the line it was found on
+type configError struct {
+	Line    int
+	Field   string
+	Message string
+}
+
+func (e configError) Error() string {
+	message := e.Message
+	if e.Field != "" {
+		message = e.Field + ": " + message
+	}
+	if e.Line > 0 {
+		return fmt.Sprintf("line %d: %s", e.Line, message)
+	}
+	return message
+}
+
+// configErrors : every problem found in a config, reported together
+type configErrors []configError
+
+func (errs configErrors) Error() string {
+	messages := make([]string, len(errs))
+	for i, e := range errs {
+		messages[i] = e.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func (errs *configErrors) add(node *yaml.Node, field string, format string, args ...interface{}) {
+	line := 0
+	if node != nil {
+		line = node.Line
+	}
+	*errs = append(*errs, configError{Line: line, Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+//ValidateConfig : check an application.yml, returning every problem found. Unless resolve is set,
+//the ${...} references are only checked for syntax and the values they stand for are not
+//validated, so that a config can be checked away from the platform it is pushed to.
+func ValidateConfig(yamlFile []byte, resolve bool) error {
+	_, err := loadApplicationConfig(yamlFile, !resolve)
+	return err
+}
+
+// parseApplicationConfig : unmarshal, resolve the ${...} references in and validate an application.yml
+func parseApplicationConfig(yamlFile []byte) (*applicationConfig, error) {
+	return loadApplicationConfig(yamlFile, false)
+}
+
+// loadApplicationConfig : parseApplicationConfig, only checking the syntax of the references when checkOnly
+func loadApplicationConfig(yamlFile []byte, checkOnly bool) (*applicationConfig, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(yamlFile, &root); err != nil {
+		return nil, err
+	}
+	var applConfig applicationConfig
+	doc := documentMapping(&root)
+	if doc == nil {
+		// An empty file is a valid, empty config.
+		return &applConfig, nil
+	}
+	// References are resolved first, the values they expand to are validated like any other.
+	// A value still holding a reference is only reported for the reference.
+	errs, unresolved := interpolateConfig(doc, checkOnly)
+	if err := doc.Decode(&applConfig); err != nil {
+		typeErr, ok := err.(*yaml.TypeError)
+		if !ok {
+			return nil, err
+		}
+		errs = append(errs, typeErrors(typeErr, unresolved)...)
+	}
+	for _, e := range validateConfig(doc, &applConfig) {
+		if _, held := unresolved[e.Field]; !held {
+			errs = append(errs, e)
+		}
+	}
+	if len(errs) > 0 {
+		sort.SliceStable(errs, func(i, j int) bool { return errs[i].Line < errs[j].Line })
+		return nil, errs
+	}
+	return &applConfig, nil
+}
+
+// typeErrors : values that could not be decoded into their setting, but for the lines still
+// holding a reference
+func typeErrors(err *yaml.TypeError, unresolved map[string]int) configErrors {
+	held := make(map[int]bool)
+	for _, line := range unresolved {
+		held[line] = true
+	}
+	var errs configErrors
+	for _, message := range err.Errors {
+		var line int
+		if _, scanErr := fmt.Sscanf(message, "line %d:", &line); scanErr == nil {
+			message = strings.TrimSpace(message[strings.Index(message, ":")+1:])
+		}
+		if !held[line] {
+			errs = append(errs, configError{Line: line, Message: message})
+		}
+	}
+	return errs
+}
+
+// validateConfig : check the decoded config against doc, the mapping node it was decoded from
+func validateConfig(doc *yaml.Node, applConfig *applicationConfig) configErrors {
+	var errs configErrors
+	errs.unknownKeys(doc, "", applicationConfig{})
+
+	switch applConfig.storageMode("") {
+	case storageMemory, storageConfig, storageMySQL, storagePostgres, storageSQLite:
+	default:
+		errs.add(mappingValue(doc, "storage_mode"), "storage_mode", "unknown mode %q, expected one of %s", applConfig.StorageMode, storageModes)
+	}
+
+	database := mappingValue(doc, "database")
+	errs.unknownKeys(database, "database", databaseSettings{})
+	if !tablePrefixPattern.MatchString(applConfig.Database.TablePrefix) {
+		errs.add(mappingValue(database, "table_prefix"), "database.table_prefix", "may only contain letters, digits and underscores")
+	}
+	if strings.ContainsAny(applConfig.Database.Name, "`\"") {
+		errs.add(mappingValue(database, "name"), "database.name", "may not contain quotes")
+	}
+	for _, limit := range []struct {
+		key   string
+		value int64
+	}{
+		{"max_open_conns", int64(applConfig.Database.MaxOpenConns)},
+		{"max_idle_conns", int64(applConfig.Database.MaxIdleConns)},
+		{"conn_max_lifetime", int64(applConfig.Database.ConnMaxLifetime)},
+		{"startup_timeout", int64(applConfig.Database.StartupTimeout)},
+		{"cache_ttl", int64(applConfig.Database.CacheTTL)},
+		{"cache_poll_interval", int64(applConfig.Database.CachePollInterval)},
+	} {
+		if limit.value < 0 {
+			errs.add(mappingValue(database, limit.key), "database."+limit.key, "may not be negative")
+		}
+	}
+
+	metrics := mappingValue(doc, "metrics")
+	errs.unknownKeys(metrics, "metrics", metricsSettings{})
+	if applConfig.Metrics.MaxIdentifiers < 0 {
+		errs.add(mappingValue(metrics, "max_identifiers"), "metrics.max_identifiers", "may not be negative")
+	}
+
+	health := mappingValue(doc, "health")
+	errs.unknownKeys(health, "health", healthSettings{})
+	if applConfig.Health.DestinationInterval < 0 {
+		errs.add(mappingValue(health, "destination_interval"), "health.destination_interval", "may not be negative")
+	}
+
+	logging := mappingValue(doc, "logging")
+	errs.unknownKeys(logging, "logging", loggingSettings{})
+	if _, err := parseLogLevel(applConfig.Logging.Level); err != nil {
+		errs.add(mappingValue(logging, "level"), "logging.level", "%v", err)
+	}
+	if _, err := newLogHandler(io.Discard, applConfig.Logging.Format); err != nil {
+		errs.add(mappingValue(logging, "format"), "logging.format", "%v", err)
+	}
+
+	tracing := mappingValue(doc, "tracing")
+	errs.unknownKeys(tracing, "tracing", tracingSettings{})
+	switch strings.ToLower(applConfig.Tracing.Exporter) {
+	case "", tracingNone, tracingStdout, tracingOTLP:
+	default:
+		errs.add(mappingValue(tracing, "exporter"), "tracing.exporter", "must be one of %s, %s, %s", tracingNone, tracingStdout, tracingOTLP)
+	}
+	if endpoint := applConfig.Tracing.Endpoint; endpoint != "" {
+		if u, err := url.Parse(endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs.add(mappingValue(tracing, "endpoint"), "tracing.endpoint", "must be an http or https URL")
+		}
+	}
+	for i, destination := range applConfig.Tracing.Propagate {
+		if destination != teamsType && destination != pagerdutyType {
+			field := fmt.Sprintf("tracing.propagate[%d]", i)
+			errs.add(sequenceItem(mappingValue(tracing, "propagate"), i), field, "must be %s or %s", teamsType, pagerdutyType)
+		}
+	}
+
+	shutdown := mappingValue(doc, "shutdown")
+	errs.unknownKeys(shutdown, "shutdown", shutdownSettings{})
+	if applConfig.Shutdown.DrainTimeout < 0 {
+		errs.add(mappingValue(shutdown, "drain_timeout"), "shutdown.drain_timeout", "may not be negative")
+	} else if timeout := applConfig.Shutdown.DrainTimeout; timeout > 0 && timeout <= helpers.DeliveryTimeout {
+		errs.add(mappingValue(shutdown, "drain_timeout"), "shutdown.drain_timeout",
+			"must be longer than the %s delivery timeout", helpers.DeliveryTimeout)
+	}
+
+	notifications := mappingValue(doc, "notifications")
+	names := make(map[string]int)
+	for i, notify := range applConfig.Notifications {
+		item := sequenceItem(notifications, i)
+		field := fmt.Sprintf("notifications[%d]", i)
+		errs.unknownKeys(item, field, notification{})
+		for _, problem := range checkNotification(notify) {
+			errs.add(mappingValueOr(item, problem.Field), field+"."+problem.Field, problem.Message)
+		}
+		if line, found := names[notify.Name]; found && notify.Name != "" {
+			errs.add(mappingValueOr(item, "name"), field+".name", "%s is already used on line %d", notify.Name, line)
+		} else {
+			names[notify.Name] = mappingValueOr(item, "name").Line
+		}
+	}
+
+	tokens := mappingValue(doc, "admin_tokens")
+	adminNames := make(map[string]bool)
+	for i, admin := range applConfig.AdminTokens {
+		item := sequenceItem(tokens, i)
+		field := fmt.Sprintf("admin_tokens[%d]", i)
+		errs.unknownKeys(item, field, adminToken{})
+		switch {
+		case admin.Name == "":
+			errs.add(item, field+".name", "is required")
+		case adminNames[admin.Name]:
+			errs.add(mappingValueOr(item, "name"), field+".name", "%s is already used", admin.Name)
+		}
+		adminNames[admin.Name] = true
+		if admin.Token == "" {
+			errs.add(mappingValueOr(item, "token"), field+".token", "is required")
+		}
+	}
+	return errs
+}
+
+// checkNotification : problems with one notifications entry, Field naming the offending key
+func checkNotification(notify notification) []configError {
+	var problems []configError
+	switch {
+	case notify.Name == "":
+		problems = append(problems, configError{Field: "name", Message: "is required"})
+	case len(notify.Name) > maxIdentifierLength:
+		problems = append(problems, configError{Field: "name", Message: fmt.Sprintf("is longer than %d characters", maxIdentifierLength)})
+	}
+	if notify.Teams == "" && notify.Pagerduty == "" {
+		problems = append(problems, configError{Field: "name", Message: "needs at least one of teams or pagerduty"})
+	}
+	if notify.Teams != "" && !validWebhookURL(notify.Teams) {
+		problems = append(problems, configError{Field: "teams", Message: "is not a valid http(s) webhook URL"})
+	}
+	if notify.Pagerduty != "" && !routingKeyPattern.MatchString(notify.Pagerduty) {
+		problems = append(problems, configError{Field: "pagerduty", Message: "is not a 32 character PagerDuty integration key"})
+	}
+	return problems
+}
+
+// validWebhookURL : absolute http or https URL with a host
+func validWebhookURL(webhook string) bool {
+	u, err := url.ParseRequestURI(webhook)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+// unknownKeys : flag keys of mapping that are not yaml tags of model, typos would otherwise go unnoticed
+func (errs *configErrors) unknownKeys(mapping *yaml.Node, field string, model interface{}) {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return
+	}
+	known := make(map[string]bool)
+	modelType := reflect.TypeOf(model)
+	for i := 0; i < modelType.NumField(); i++ {
+		known[strings.Split(modelType.Field(i).Tag.Get("yaml"), ",")[0]] = true
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		key := mapping.Content[i]
+		if !known[key.Value] {
+			name := key.Value
+			if field != "" {
+				name = field + "." + name
+			}
+			errs.add(key, name, "is not a known setting")
+		}
+	}
+}
+
+// documentMapping : the top-level mapping of a parsed document, nil for an empty one
+func documentMapping(root *yaml.Node) *yaml.Node {
+	if root.Kind == yaml.DocumentNode && len(root.Content) > 0 {
+		return root.Content[0]
+	}
+	return nil
+}
+
+// mappingValue : the value node of key in mapping, nil when absent
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// mappingValueOr : the value node of key, or mapping itself when the key is absent
+func mappingValueOr(mapping *yaml.Node, key string) *yaml.Node {
+	if value := mappingValue(mapping, key); value != nil {
+		return value
+	}
+	if mapping == nil {
+		return &yaml.Node{}
+	}
+	return mapping
+}
+
+// sequenceItem : the i-th node of a sequence, nil when out of range
+func sequenceItem(sequence *yaml.Node, i int) *yaml.Node {
+	if sequence == nil || sequence.Kind != yaml.SequenceNode || i >= len(sequence.Content) {
+		return nil
+	}
+	return sequence.Content[i]
+}
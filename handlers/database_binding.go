@@ -0,0 +1,189 @@
+package handlers
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/tushardag/pcf-eventalert-integration/helpers"
+)
+
+// Credential keys tried in order, covering p.mysql, p-mysql, cleardb, the Postgres
+// brokers and user-provided services
+var (
+	uriKeys      = []string{"uri", "url", "database_uri"}
+	jdbcKeys     = []string{"jdbcUrl", "jdbc_url", "jdbc_uri"}
+	hostKeys     = []string{"hostname", "host"}
+	portKeys     = []string{"port"}
+	usernameKeys = []string{"username", "user"}
+	passwordKeys = []string{"password"}
+	databaseKeys = []string{"name", "database", "db_name", "dbname"}
+	caCertKeys   = []string{"ca_certificate", "ca_cert", "sslrootcert", "tls.cert.ca"}
+)
+
+// resolveDatabaseBinding : DBConfig of the bound database service whose name, label or one of its
+// tags is selector. An empty selector takes the one service that is recognisably MySQL or PostgreSQL,
+// several of them have to be told apart by a selector.
+func resolveDatabaseBinding(services helpers.VCAPServices, selector string) (DBConfig, error) {
+	labels := make([]string, 0, len(services))
+	for label := range services {
+		labels = append(labels, label)
+	}
+	sort.Strings(labels)
+	var matches []helpers.ServiceBinding
+	for _, label := range labels {
+		for _, binding := range services[label] {
+			if selector != "" && !bindingMatches(binding, selector) {
+				continue
+			}
+			if bindingEngine(binding) == "" {
+				if selector != "" {
+					return DBConfig{}, fmt.Errorf("vcap: service %s is neither a MySQL nor a PostgreSQL database", binding.Name)
+				}
+				continue
+			}
+			matches = append(matches, binding)
+		}
+	}
+	switch {
+	case len(matches) == 0 && selector != "":
+		return DBConfig{}, fmt.Errorf("vcap: no service named, labelled or tagged %s is bound to this app", selector)
+	case len(matches) == 0:
+		return DBConfig{}, fmt.Errorf("vcap: unable to find a service tagged or labelled mysql or postgres")
+	case len(matches) > 1:
+		names := make([]string, len(matches))
+		for i, binding := range matches {
+			names[i] = binding.Name
+		}
+		if selector != "" {
+			return DBConfig{}, fmt.Errorf("vcap: services %s all match %s, set database_service to the name of one", strings.Join(names, ", "), selector)
+		}
+		return DBConfig{}, fmt.Errorf("vcap: databases %s are all bound, set database_service to the name of one", strings.Join(names, ", "))
+	}
+
+	binding := matches[0]
+	config, err := bindingCredentials(binding)
+	if err != nil {
+		return DBConfig{}, err
+	}
+	config.Driver = bindingEngine(binding)
+	slog.Info("Using bound database service", "engine", config.Driver, "service", binding.Name)
+	return config, nil
+}
+
+// bindingMatches : selector names the binding, its instance, its label or one of its tags
+func bindingMatches(binding helpers.ServiceBinding, selector string) bool {
+	for _, candidate := range append([]string{binding.Name, binding.InstanceName, binding.Label}, binding.Tags...) {
+		if strings.EqualFold(candidate, selector) {
+			return true
+		}
+	}
+	return false
+}
+
+// bindingEngine : mysql or postgres, from the label or tags or else from the scheme of the credentials URI
+func bindingEngine(binding helpers.ServiceBinding) string {
+	for _, tag := range append([]string{binding.Label}, binding.Tags...) {
+		tag = strings.ToLower(tag)
+		switch {
+		case strings.Contains(tag, "postgres"), strings.Contains(tag, "elephantsql"):
+			return storagePostgres
+		case strings.Contains(tag, "mysql"), strings.Contains(tag, "cleardb"), strings.Contains(tag, "mariadb"):
+			return storageMySQL
+		}
+	}
+	// user-provided services carry no meaningful label, the URI tells the engine
+	uri := strings.TrimPrefix(credential(binding, append(uriKeys, jdbcKeys...)), "jdbc:")
+	switch {
+	case strings.HasPrefix(uri, "postgres://"), strings.HasPrefix(uri, "postgresql://"):
+		return storagePostgres
+	case strings.HasPrefix(uri, "mysql://"), strings.HasPrefix(uri, "mariadb://"):
+		return storageMySQL
+	}
+	return ""
+}
+
+// bindingCredentials : connection settings from the uri or jdbcUrl of a binding, completed or
+// overridden by the discrete hostname, port, username, password and name credentials
+func bindingCredentials(binding helpers.ServiceBinding) (DBConfig, error) {
+	var config DBConfig
+	if uri := credential(binding, uriKeys); uri != "" {
+		if err := config.applyURI(uri); err != nil {
+			return DBConfig{}, fmt.Errorf("vcap: service %s: %v", binding.Name, err)
+		}
+	} else if jdbc := credential(binding, jdbcKeys); jdbc != "" {
+		if err := config.applyURI(strings.TrimPrefix(jdbc, "jdbc:")); err != nil {
+			return DBConfig{}, fmt.Errorf("vcap: service %s: %v", binding.Name, err)
+		}
+	}
+	if host := credential(binding, hostKeys); host != "" {
+		config.Host = host
+	}
+	if port := credential(binding, portKeys); port != "" {
+		p, err := strconv.Atoi(port)
+		if err != nil {
+			return DBConfig{}, fmt.Errorf("vcap: service %s: port %q is not a number", binding.Name, port)
+		}
+		config.Port = p
+	}
+	if username := credential(binding, usernameKeys); username != "" {
+		config.Username = username
+	}
+	if password := credential(binding, passwordKeys); password != "" {
+		config.Password = password
+	}
+	if database := credential(binding, databaseKeys); database != "" {
+		config.Database = database
+	}
+	config.CACert = credential(binding, caCertKeys)
+	if config.Host == "" {
+		return DBConfig{}, fmt.Errorf("vcap: service %s has no hostname, uri or jdbcUrl credential", binding.Name)
+	}
+	if config.SSLMode == "" {
+		config.SSLMode = "require"
+	}
+	return config, nil
+}
+
+// applyURI : fill config from a mysql:// or postgres:// URI, user and password may also be query parameters as in JDBC URLs
+func (config *DBConfig) applyURI(uri string) error {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("could not parse the database URI")
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		host, port = u.Host, ""
+	}
+	config.Host = host
+	if port != "" {
+		if config.Port, err = strconv.Atoi(port); err != nil {
+			return fmt.Errorf("port %q is not a number", port)
+		}
+	}
+	config.Database = strings.TrimPrefix(u.Path, "/")
+	query := u.Query()
+	config.Username, config.Password = query.Get("user"), query.Get("password")
+	if u.User != nil {
+		config.Username = u.User.Username()
+		if password, ok := u.User.Password(); ok {
+			config.Password = password
+		}
+	}
+	config.SSLMode = query.Get("sslmode")
+	return nil
+}
+
+// credential : the first of keys present in the binding's credentials, empty when none is
+func credential(binding helpers.ServiceBinding, keys []string) string {
+	for _, key := range keys {
+		if value, err := binding.Credential(key); err == nil && value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -1,139 +1,123 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
-	"database/sql/driver"
+	"encoding/json"
 	"fmt"
-	"log"
-	"net/url"
+	"log/slog"
+	"strings"
 
-	// importing mysql driver in conjunction with database/sql package
-	_ "github.com/go-sql-driver/mysql"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 )
 
-//createMappingTable : verify and create db and table for first time setup
-var createMappingTable = []string{
-	`CREATE DATABASE IF NOT EXISTS event_router_mapping DEFAULT CHARACTER SET = 'utf8' DEFAULT COLLATE 'utf8_general_ci';`,
-	`USE event_router_mapping;`,
-	`CREATE TABLE IF NOT EXISTS route_mapping (
-		identifier VARCHAR(30) NOT NULL,
-		routeType VARCHAR(10) NOT NULL,
-		postURL VARCHAR(255) NOT NULL,
-		description TEXT NULL,
-		PRIMARY KEY (identifier, routeType)
-	)`,
-}
+// mappingDBName : schema holding the route mapping tables
+const mappingDBName = "event_router_mapping"
 
-//MysqlDB : persists event mapping to MySQL interface
-type mysqlDB struct {
-	conn *sql.DB
+//sqlDB : persists event mapping to a MySQL, PostgreSQL or SQLite database
+type sqlDB struct {
+	conn    *sql.DB
+	dialect *sqlDialect
+	// tables renames the tables in every statement when a prefix is configured
+	tables *strings.Replacer
 
 	fetchAll   *sql.Stmt
 	retriveOne *sql.Stmt
 	createNew  *sql.Stmt
+	updateOne  *sql.Stmt
 	removeOne  *sql.Stmt
-}
 
-//mappingObject : Ensure mysqlDB conforms to the interface.
-var _ mappingDatabase = &mysqlDB{}
+	insertAudit *sql.Stmt
+	fetchAudit  *sql.Stmt
 
-// dbConnectionString : Returns a connection string suitable for sql.Open
-func (config *MySQLConfig) dbConnectionString(schemaName string) string {
-	var returnString string
-	if config.Username != "" {
-		returnString = config.Username
-		if config.Password != "" {
-			returnString = returnString + ":" + config.Password
-		}
-		returnString = returnString + "@"
-	}
-	return fmt.Sprintf("%stcp([%s]:%d)/%s", returnString, config.Host, config.Port, schemaName)
+	fetchGeneration *sql.Stmt
+	bumpGeneration  *sql.Stmt
 }
 
-//NewDBConnection : Initiating new DB connection instance
-func newDBConnection(config MySQLConfig) (*mysqlDB, error) {
-	// Check database and table exists. If not, create it.
-	if err := config.ensureTableExists("event_router_mapping"); err != nil {
-		return nil, err
+//mappingObject : Ensure sqlDB conforms to the interface.
+var _ mappingDatabase = &sqlDB{}
+
+//NewDBConnection : Initiating new DB connection instance for the engine named in config.Driver
+func newDBConnection(config DBConfig) (*sqlDB, error) {
+	var databaseConn sqlDB
+	switch config.Driver {
+	case storagePostgres:
+		databaseConn.dialect = postgresDialect
+	case storageSQLite:
+		databaseConn.dialect = sqliteDialect
+	default:
+		databaseConn.dialect = mysqlDialect
 	}
-	var databaseConn mysqlDB
+	name := databaseConn.dialect.name
+
+	// route_mapping also covers route_mapping_audit and the index names derived from it
+	databaseConn.tables = strings.NewReplacer(
+		"route_mapping", config.TablePrefix+"route_mapping",
+		"schema_migrations", config.TablePrefix+"schema_migrations",
+	)
+
 	var err error
-	databaseConn.conn, err = sql.Open("mysql", config.dbConnectionString("event_router_mapping"))
+	databaseConn.conn, err = databaseConn.dialect.connect(config)
 	if err != nil {
-		return nil, fmt.Errorf("mysql: could not get a connection: %v", err)
+		return nil, err
+	}
+	if config.MaxOpenConns > 0 {
+		databaseConn.conn.SetMaxOpenConns(config.MaxOpenConns)
+	}
+	if config.MaxIdleConns > 0 {
+		databaseConn.conn.SetMaxIdleConns(config.MaxIdleConns)
+	}
+	if config.ConnMaxLifetime > 0 {
+		databaseConn.conn.SetConnMaxLifetime(config.ConnMaxLifetime)
 	}
 	if err := databaseConn.conn.Ping(); err != nil {
 		databaseConn.conn.Close()
-		return nil, fmt.Errorf("mysql: could not establish a good connection: %v", err)
+		return nil, fmt.Errorf("%s: could not establish a good connection: %v", name, err)
+	}
+	// Bring the tables up to date before preparing statements against them.
+	if err := databaseConn.migrateSchema(); err != nil {
+		databaseConn.conn.Close()
+		return nil, err
 	}
 
 	// Prepared statements. The actual SQL queries are in the code near the relevant method (e.g. ListRoutes).
-	if databaseConn.fetchAll, err = databaseConn.conn.Prepare(listStatement); err != nil {
-		log.Println("Failed to prepare list statement")
-		return nil, fmt.Errorf("mysql: prepare list: %v", err)
-	}
-	if databaseConn.createNew, err = databaseConn.conn.Prepare(insertStatement); err != nil {
-		log.Println("Failed to prepare insert statement")
-		return nil, fmt.Errorf("mysql: prepare insert: %v", err)
-	}
-	if databaseConn.retriveOne, err = databaseConn.conn.Prepare(getStatement); err != nil {
-		log.Println("Failed to prepare get statement")
-		return nil, fmt.Errorf("mysql: prepare get: %v", err)
+	statements := []struct {
+		stmt        **sql.Stmt
+		name, query string
+	}{
+		{&databaseConn.fetchAll, "list", listStatement},
+		{&databaseConn.createNew, "insert", insertStatement},
+		{&databaseConn.retriveOne, "get", getStatement},
+		{&databaseConn.updateOne, "update", updateStatement},
+		{&databaseConn.removeOne, "delete", deleteStatement},
+		{&databaseConn.insertAudit, "audit insert", insertAuditStatement},
+		{&databaseConn.fetchAudit, "audit list", listAuditStatement},
+		{&databaseConn.fetchGeneration, "generation", generationStatement},
+		{&databaseConn.bumpGeneration, "generation bump", bumpGenerationStatement},
 	}
-	if databaseConn.removeOne, err = databaseConn.conn.Prepare(deleteStatement); err != nil {
-		log.Println("Failed to prepare delete statement")
-		return nil, fmt.Errorf("mysql: prepare delete: %v", err)
+	for _, s := range statements {
+		if *s.stmt, err = databaseConn.conn.Prepare(databaseConn.statement(s.query)); err != nil {
+			slog.Error("Failed to prepare statement", "statement", s.name)
+			databaseConn.conn.Close()
+			return nil, fmt.Errorf("%s: prepare %s: %v", name, s.name, err)
+		}
 	}
-	fmt.Println("Returning the DB instance")
+	slog.Debug("Returning the DB instance", "driver", name)
 	return &databaseConn, nil
 }
 
-func (config MySQLConfig) ensureTableExists(dbName string) error {
-	conn, err := sql.Open("mysql", config.dbConnectionString(""))
-	if err != nil {
-		return fmt.Errorf("mysql: could not get a connection: %v", err)
-	}
-	defer conn.Close()
-
-	if conn.Ping() == driver.ErrBadConn {
-		return fmt.Errorf("mysql: could not connect to the database. " +
-			"could be bad address, or this address is not whitelisted for access.")
-	}
-
-	if _, err := conn.Exec("USE " + dbName); err != nil {
-		fmt.Println("Creating event_router_mapping DB and route_mapping Table")
-		return createTable(conn)
-	}
-
-	if _, err := conn.Exec("DESCRIBE route_mapping"); err != nil {
-		fmt.Println("Found event_router_mapping DB. Creating route_mapping Table")
-		return createTable(conn)
-	}
-	return nil
+// statement : query with the configured table names and the dialect's placeholders
+func (db *sqlDB) statement(query string) string {
+	return db.dialect.rebind(db.tables.Replace(query))
 }
 
 // Close closes the database, freeing up any resources.
-func (db *mysqlDB) close() {
+func (db *sqlDB) close() {
 	db.conn.Close()
 }
 
-func urlToString(dbURL *url.URL) string {
-	return fmt.Sprintf(
-		"%v@tcp(%v)%v?parseTime=true", dbURL.User, dbURL.Host, dbURL.Path,
-	)
-}
-
-// createTable creates the table, and if necessary, the database.
-func createTable(conn *sql.DB) error {
-	for _, stmt := range createMappingTable {
-		_, err := conn.Exec(stmt)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
-
 // rowScanner is implemented by sql.Row and sql.Rows
 type rowScanner interface {
 	Scan(dest ...interface{}) error
@@ -146,8 +130,10 @@ func scanRoute(s rowScanner) (*routes, error) {
 		routeType   sql.NullString
 		postURL     sql.NullString
 		description sql.NullString
+		version     sql.NullInt64
+		updatedAt   sql.NullTime
 	)
-	if err := s.Scan(&identifier, &routeType, &postURL, &description); err != nil {
+	if err := s.Scan(&identifier, &routeType, &postURL, &description, &version, &updatedAt); err != nil {
 		return nil, err
 	}
 
@@ -156,15 +142,21 @@ func scanRoute(s rowScanner) (*routes, error) {
 		RouteType:   routeType.String,
 		PostURL:     postURL.String,
 		Description: description.String,
+		Version:     version.Int64,
+	}
+	if updatedAt.Valid {
+		route.UpdatedAt = &updatedAt.Time
 	}
 	return route, nil
 }
 
-//
-const listStatement = `SELECT * FROM route_mapping`
+// routeColumns lists the columns read by scanRoute, in scan order.
+const routeColumns = `identifier, routeType, postURL, description, version, updatedAt`
+
+const listStatement = `SELECT ` + routeColumns + ` FROM route_mapping`
 
 // ListRoutes returns a list of mapping records
-func (db *mysqlDB) listRoutes() ([]*routes, error) {
+func (db *sqlDB) listRoutes() ([]*routes, error) {
 	rows, err := db.fetchAll.Query()
 	if err != nil {
 		return nil, err
@@ -175,7 +167,7 @@ func (db *mysqlDB) listRoutes() ([]*routes, error) {
 	for rows.Next() {
 		route, err := scanRoute(rows)
 		if err != nil {
-			return nil, fmt.Errorf("mysql: could not read row: %v", err)
+			return nil, fmt.Errorf("%s: could not read row: %v", db.dialect.name, err)
 		}
 
 		routeEntries = append(routeEntries, route)
@@ -184,53 +176,233 @@ func (db *mysqlDB) listRoutes() ([]*routes, error) {
 	return routeEntries, nil
 }
 
-const getStatement = "SELECT * FROM route_mapping WHERE identifier = ? and routeType = ?"
+const getStatement = `SELECT ` + routeColumns + ` FROM route_mapping WHERE identifier = ? and routeType = ?`
 
 // GetRoute retrieves a Route by its identifier.
-func (db *mysqlDB) getRoute(identifier string, routeType string) (*routes, error) {
-	route, err := scanRoute(db.retriveOne.QueryRow(identifier, routeType))
+func (db *sqlDB) getRoute(ctx context.Context, identifier string, routeType string) (*routes, error) {
+	ctx, span := tracer.Start(ctx, "db.getRoute", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("db.system", db.dialect.name),
+		attribute.String("db.operation", "SELECT"),
+		attribute.String("db.sql.table", db.tables.Replace("route_mapping")),
+	))
+	defer span.End()
+	route, err := scanRoute(db.retriveOne.QueryRowContext(ctx, identifier, routeType))
+	if err != nil && err != sql.ErrNoRows {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, "query failed")
+	}
 	if err == sql.ErrNoRows {
-		return nil, fmt.Errorf("mysql: could not find route with identifier %s of type %s", identifier, routeType)
+		return nil, errRouteNotFound
 	}
 	if err != nil {
-		return nil, fmt.Errorf("mysql: could not get route: %v", err)
+		return nil, fmt.Errorf("%s: could not get route: %v", db.dialect.name, err)
 	}
 	return route, nil
 }
 
+// LatestRoute : the database is always current, same as GetRoute.
+func (db *sqlDB) latestRoute(ctx context.Context, identifier string, routeType string) (*routes, error) {
+	return db.getRoute(ctx, identifier, routeType)
+}
+
 const insertStatement = `
   INSERT INTO route_mapping (
 	  identifier, routeType, postURL, description) 
 	  VALUES (?, ?, ?, ?)`
 
 // AddRoute saves a new Route mapping.
-func (db *mysqlDB) addRoute(rt *routes) error {
-	_, err := execAffectingOneRow(db.createNew, rt.Identifier, rt.RouteType, rt.PostURL, rt.Description)
+func (db *sqlDB) addRoute(rt *routes) error {
+	_, err := db.createNew.Exec(rt.Identifier, rt.RouteType, rt.PostURL, rt.Description)
+	if err != nil && db.dialect.isDuplicate(err) {
+		return errRouteExists
+	}
+	if err != nil {
+		return fmt.Errorf("%s: could not execute statement: %v", db.dialect.name, err)
+	}
+	db.touch()
+	return nil
+}
+
+const updateStatement = `
+  UPDATE route_mapping
+	  SET postURL = ?, description = ?, version = version + 1, updatedAt = CURRENT_TIMESTAMP
+	  WHERE identifier = ? and routeType = ? and version = ?`
+
+// UpdateRoute overwrites a Route mapping if nobody changed it since the given version was read.
+func (db *sqlDB) updateRoute(rt *routes, version int64) error {
+	r, err := db.updateOne.Exec(rt.PostURL, rt.Description, rt.Identifier, rt.RouteType, version)
+	if err != nil {
+		return fmt.Errorf("%s: could not execute statement: %v", db.dialect.name, err)
+	}
+	rowsAffected, err := r.RowsAffected()
 	if err != nil {
+		return fmt.Errorf("%s: could not get rows affected: %v", db.dialect.name, err)
+	}
+	if rowsAffected == 1 {
+		db.touch()
+		return nil
+	}
+	// Nothing matched: either the mapping is gone or its version moved on.
+	if _, err := db.getRoute(context.Background(), rt.Identifier, rt.RouteType); err != nil {
 		return err
 	}
+	return errVersionConflict
+}
+
+// ApplyRoutes upserts and deletes route mappings in one transaction, nothing is kept if any statement fails.
+func (db *sqlDB) applyRoutes(upserts []*routes, deletes []*routes) error {
+	tx, err := db.conn.Begin()
+	if err != nil {
+		return fmt.Errorf("%s: could not begin transaction: %v", db.dialect.name, err)
+	}
+	for _, rt := range upserts {
+		if _, err := tx.Exec(db.statement(db.dialect.upsertStatement), rt.Identifier, rt.RouteType, rt.PostURL, rt.Description); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("%s: could not save %s route for %s: %v", db.dialect.name, rt.RouteType, rt.Identifier, err)
+		}
+	}
+	for _, rt := range deletes {
+		if _, err := execAffectingOneRow(tx.Stmt(db.removeOne), rt.Identifier, rt.RouteType); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.Stmt(db.bumpGeneration).Exec(); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("%s: could not bump the mapping generation: %v", db.dialect.name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("%s: could not commit transaction: %v", db.dialect.name, err)
+	}
 	return nil
 }
 
 const deleteStatement = `DELETE FROM route_mapping WHERE identifier = ? and routeType = ?`
 
 // DeleteRoute : removes a given route by its identifier.
-func (db *mysqlDB) deleteRoute(identifier string, routeType string) error {
-	_, err := execAffectingOneRow(db.removeOne, identifier, routeType)
-	return err
+func (db *sqlDB) deleteRoute(identifier string, routeType string) error {
+	r, err := db.removeOne.Exec(identifier, routeType)
+	if err != nil {
+		return fmt.Errorf("%s: could not execute statement: %v", db.dialect.name, err)
+	}
+	if rowsAffected, err := r.RowsAffected(); err == nil && rowsAffected == 0 {
+		return errRouteNotFound
+	}
+	db.touch()
+	return nil
+}
+
+const generationStatement = `SELECT generation FROM route_mapping_generation WHERE id = 1`
+
+const bumpGenerationStatement = `UPDATE route_mapping_generation SET generation = generation + 1 WHERE id = 1`
+
+// Generation is bumped by every change to route_mapping, instances poll it to learn their cache is stale.
+func (db *sqlDB) generation(ctx context.Context) (int64, error) {
+	var generation int64
+	if err := db.fetchGeneration.QueryRowContext(ctx).Scan(&generation); err != nil {
+		return 0, fmt.Errorf("%s: could not read the mapping generation: %v", db.dialect.name, err)
+	}
+	return generation, nil
+}
+
+// touch : bump the generation after a single statement change. The change itself
+// stands if this fails, other instances then pick it up when their cache expires.
+func (db *sqlDB) touch() {
+	if _, err := db.bumpGeneration.Exec(); err != nil {
+		slog.Warn("Could not bump the mapping generation", "driver", db.dialect.name, "error", err)
+	}
 }
 
 // execAffectingOneRow executes a given statement, expecting one row to be affected.
 func execAffectingOneRow(stmt *sql.Stmt, args ...interface{}) (sql.Result, error) {
 	r, err := stmt.Exec(args...)
 	if err != nil {
-		return r, fmt.Errorf("mysql: could not execute statement: %v", err)
+		return r, fmt.Errorf("database: could not execute statement: %v", err)
 	}
 	rowsAffected, err := r.RowsAffected()
 	if err != nil {
-		return r, fmt.Errorf("mysql: could not get rows affected: %v", err)
+		return r, fmt.Errorf("database: could not get rows affected: %v", err)
 	} else if rowsAffected != 1 {
-		return r, fmt.Errorf("mysql: expected 1 row affected, got %d", rowsAffected)
+		return r, fmt.Errorf("database: expected 1 row affected, got %d", rowsAffected)
 	}
 	return r, nil
 }
+
+const insertAuditStatement = `
+  INSERT INTO route_mapping_audit (
+	  identifier, routeType, action, actor, sourceIP, changedAt, beforeValue, afterValue)
+	  VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+// RecordAudit appends a change to route_mapping_audit.
+func (db *sqlDB) recordAudit(rec *auditRecord) error {
+	before, err := marshalAuditValues(rec.Before)
+	if err != nil {
+		return err
+	}
+	after, err := marshalAuditValues(rec.After)
+	if err != nil {
+		return err
+	}
+	_, err = execAffectingOneRow(db.insertAudit, rec.Identifier, rec.RouteType, rec.Action,
+		rec.Actor, rec.SourceIP, rec.ChangedAt, before, after)
+	return err
+}
+
+// Empty identifier or type match every row, so one prepared statement serves all filters.
+const listAuditStatement = `
+  SELECT id, identifier, routeType, action, actor, sourceIP, changedAt, beforeValue, afterValue
+	  FROM route_mapping_audit
+	  WHERE (? = '' OR identifier = ?) and (? = '' OR routeType = ?) and changedAt >= ? and changedAt <= ?
+	  ORDER BY id DESC LIMIT ?`
+
+// ListAudit returns the audit records matching filter, newest first.
+func (db *sqlDB) listAudit(filter auditFilter) ([]*auditRecord, error) {
+	rows, err := db.fetchAudit.Query(filter.Identifier, filter.Identifier, filter.RouteType, filter.RouteType,
+		filter.Since, filter.Until, filter.Limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []*auditRecord
+	for rows.Next() {
+		var (
+			rec           auditRecord
+			before, after sql.NullString
+		)
+		if err := rows.Scan(&rec.ID, &rec.Identifier, &rec.RouteType, &rec.Action, &rec.Actor,
+			&rec.SourceIP, &rec.ChangedAt, &before, &after); err != nil {
+			return nil, fmt.Errorf("%s: could not read audit row: %v", db.dialect.name, err)
+		}
+		if rec.Before, err = unmarshalAuditValues(before); err != nil {
+			return nil, err
+		}
+		if rec.After, err = unmarshalAuditValues(after); err != nil {
+			return nil, err
+		}
+		records = append(records, &rec)
+	}
+	return records, rows.Err()
+}
+
+func marshalAuditValues(values *auditValues) (sql.NullString, error) {
+	if values == nil {
+		return sql.NullString{}, nil
+	}
+	enc, err := json.Marshal(values)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(enc), Valid: true}, nil
+}
+
+func unmarshalAuditValues(column sql.NullString) (*auditValues, error) {
+	if !column.Valid {
+		return nil, nil
+	}
+	var values auditValues
+	if err := json.Unmarshal([]byte(column.String), &values); err != nil {
+		return nil, fmt.Errorf("database: could not decode audit values: %v", err)
+	}
+	return &values, nil
+}
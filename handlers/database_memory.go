@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// maxMemoryAudit : audit records kept by the in-memory store, oldest are dropped first
+const maxMemoryAudit = 1000
+
+//memoryDB : keeps event mappings in memory, seeded from the notifications block or a mappings file
+type memoryDB struct {
+	mu sync.RWMutex
+
+	routes      map[string]*routes
+	audit       []*auditRecord
+	nextAuditID int64
+
+	// mappingsFile receives every change when set, in the notifications block layout.
+	mappingsFile string
+}
+
+//mappingObject : Ensure memoryDB conforms to the interface.
+var _ mappingDatabase = &memoryDB{}
+
+// newMemoryDB : seed from mappingsFile when it exists, else from the notifications block
+func newMemoryDB(notifications []notification, mappingsFile string) (*memoryDB, error) {
+	db := &memoryDB{routes: make(map[string]*routes), mappingsFile: mappingsFile}
+	if mappingsFile != "" {
+		content, err := ioutil.ReadFile(mappingsFile)
+		switch {
+		case err == nil:
+			var saved routeExport
+			if err := yaml.Unmarshal(content, &saved); err != nil {
+				return nil, fmt.Errorf("memory: could not parse %s: %v", mappingsFile, err)
+			}
+			slog.Info("Loading route mappings", "path", mappingsFile)
+			notifications = saved.Notifications
+		case !os.IsNotExist(err):
+			return nil, fmt.Errorf("memory: could not read %s: %v", mappingsFile, err)
+		}
+	}
+	now := time.Now().UTC()
+	for _, notify := range notifications {
+		for _, rt := range notify.routes() {
+			rt.Version = 1
+			rt.UpdatedAt = &now
+			db.routes[routeKey(rt)] = rt
+		}
+	}
+	return db, nil
+}
+
+// ListRoutes returns a list of mapping records, ordered by identifier and type
+func (db *memoryDB) listRoutes() ([]*routes, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return db.sortedRoutes(), nil
+}
+
+// GetRoute retrieves a copy of a Route by its identifier.
+func (db *memoryDB) getRoute(_ context.Context, identifier string, routeType string) (*routes, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	rt, found := db.routes[identifier+"/"+routeType]
+	if !found {
+		return nil, errRouteNotFound
+	}
+	route := *rt
+	return &route, nil
+}
+
+// LatestRoute : memory is always current, same as GetRoute.
+func (db *memoryDB) latestRoute(ctx context.Context, identifier string, routeType string) (*routes, error) {
+	return db.getRoute(ctx, identifier, routeType)
+}
+
+// AddRoute saves a new Route mapping.
+func (db *memoryDB) addRoute(rt *routes) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, found := db.routes[routeKey(rt)]; found {
+		return errRouteExists
+	}
+	return db.commit(func() {
+		db.put(rt, 1)
+	})
+}
+
+// UpdateRoute overwrites a Route mapping if nobody changed it since the given version was read.
+func (db *memoryDB) updateRoute(rt *routes, version int64) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	existing, found := db.routes[routeKey(rt)]
+	if !found {
+		return errRouteNotFound
+	}
+	if existing.Version != version {
+		return errVersionConflict
+	}
+	return db.commit(func() {
+		db.put(rt, version+1)
+	})
+}
+
+// DeleteRoute : removes a given route by its identifier.
+func (db *memoryDB) deleteRoute(identifier string, routeType string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	if _, found := db.routes[identifier+"/"+routeType]; !found {
+		return errRouteNotFound
+	}
+	return db.commit(func() {
+		delete(db.routes, identifier+"/"+routeType)
+	})
+}
+
+// ApplyRoutes upserts and deletes route mappings all at once, nothing changes if any delete is unknown.
+func (db *memoryDB) applyRoutes(upserts []*routes, deletes []*routes) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, rt := range deletes {
+		if _, found := db.routes[routeKey(rt)]; !found {
+			return fmt.Errorf("memory: could not delete %s route for %s: %v", rt.RouteType, rt.Identifier, errRouteNotFound)
+		}
+	}
+	return db.commit(func() {
+		for _, rt := range upserts {
+			var version int64 = 1
+			if existing, found := db.routes[routeKey(rt)]; found {
+				version = existing.Version + 1
+			}
+			db.put(rt, version)
+		}
+		for _, rt := range deletes {
+			delete(db.routes, routeKey(rt))
+		}
+	})
+}
+
+// RecordAudit appends a change to the in-memory audit trail.
+func (db *memoryDB) recordAudit(rec *auditRecord) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.nextAuditID++
+	stored := *rec
+	stored.ID = db.nextAuditID
+	db.audit = append(db.audit, &stored)
+	if len(db.audit) > maxMemoryAudit {
+		db.audit = db.audit[len(db.audit)-maxMemoryAudit:]
+	}
+	return nil
+}
+
+// ListAudit returns the audit records matching filter, newest first.
+func (db *memoryDB) listAudit(filter auditFilter) ([]*auditRecord, error) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	var records []*auditRecord
+	for i := len(db.audit) - 1; i >= 0 && len(records) < filter.Limit; i-- {
+		rec := db.audit[i]
+		if (filter.Identifier != "" && rec.Identifier != filter.Identifier) ||
+			(filter.RouteType != "" && rec.RouteType != filter.RouteType) ||
+			rec.ChangedAt.Before(filter.Since) || rec.ChangedAt.After(filter.Until) {
+			continue
+		}
+		records = append(records, rec)
+	}
+	return records, nil
+}
+
+// reseedChange : a mapping changed by reseed, before is nil for a create and after for a delete
+type reseedChange struct {
+	before, after *routes
+}
+
+// reseed applies what changed between the previous and the next notifications block. Mappings
+// the block does not mention, created through the API, are left alone, and so are the ones
+// whose entry did not change, keeping edits made through the API since.
+func (db *memoryDB) reseed(previous []notification, next []notification) ([]reseedChange, error) {
+	seeded := notificationRoutes(previous)
+	wanted := notificationRoutes(next)
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	var changes []reseedChange
+	err := db.commit(func() {
+		for _, rt := range sortedCopies(wanted) {
+			key := routeKey(rt)
+			existing, found := db.routes[key]
+			if seed, ok := seeded[key]; ok && seed.PostURL == rt.PostURL && seed.Description == rt.Description {
+				continue
+			}
+			switch {
+			case !found:
+				db.put(rt, 1)
+			case existing.PostURL != rt.PostURL || existing.Description != rt.Description:
+				db.put(rt, existing.Version+1)
+			default:
+				continue
+			}
+			changes = append(changes, reseedChange{before: existing, after: db.routes[key]})
+		}
+		for _, rt := range sortedCopies(seeded) {
+			key := routeKey(rt)
+			existing, found := db.routes[key]
+			if _, kept := wanted[key]; kept || !found {
+				continue
+			}
+			delete(db.routes, key)
+			changes = append(changes, reseedChange{before: existing})
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changes, nil
+}
+
+// notificationRoutes : the route mappings of a notifications block, keyed by routeKey
+func notificationRoutes(notifications []notification) map[string]*routes {
+	routeMap := make(map[string]*routes)
+	for _, notify := range notifications {
+		for _, rt := range notify.routes() {
+			routeMap[routeKey(rt)] = rt
+		}
+	}
+	return routeMap
+}
+
+// Close : nothing to free, every change is already written back.
+func (db *memoryDB) close() {}
+
+// put stores a copy of rt at the given version. Callers hold the write lock.
+func (db *memoryDB) put(rt *routes, version int64) {
+	now := time.Now().UTC()
+	stored := *rt
+	stored.Version = version
+	stored.UpdatedAt = &now
+	db.routes[routeKey(rt)] = &stored
+}
+
+// commit applies change and writes the result back. The change is undone when the
+// write fails, so memory and the mappings file never disagree. Callers hold the write lock.
+func (db *memoryDB) commit(change func()) error {
+	previous := make(map[string]*routes, len(db.routes))
+	for key, rt := range db.routes {
+		previous[key] = rt
+	}
+	change()
+	if err := db.writeBack(); err != nil {
+		db.routes = previous
+		return err
+	}
+	return nil
+}
+
+// writeBack saves the mappings to mappingsFile through a temporary file, so a crash never leaves it half written.
+func (db *memoryDB) writeBack() error {
+	if db.mappingsFile == "" {
+		return nil
+	}
+	enc, err := marshalYAML(routeExport{Notifications: notificationsFromRoutes(db.sortedRoutes())})
+	if err != nil {
+		return fmt.Errorf("memory: could not encode mappings: %v", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(db.mappingsFile), ".mappings-*.yml")
+	if err != nil {
+		return fmt.Errorf("memory: could not write %s: %v", db.mappingsFile, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(enc); err != nil {
+		tmp.Close()
+		return fmt.Errorf("memory: could not write %s: %v", db.mappingsFile, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("memory: could not write %s: %v", db.mappingsFile, err)
+	}
+	if err := os.Rename(tmp.Name(), db.mappingsFile); err != nil {
+		return fmt.Errorf("memory: could not write %s: %v", db.mappingsFile, err)
+	}
+	return nil
+}
+
+// sortedRoutes : copies of all routes ordered by identifier and type. Callers hold the lock.
+func (db *memoryDB) sortedRoutes() []*routes {
+	return sortedCopies(db.routes)
+}
+
+// sortedCopies : copies of the routes in a map keyed by routeKey, in key order
+func sortedCopies(routeMap map[string]*routes) []*routes {
+	routeEntries := make([]*routes, 0, len(routeMap))
+	for _, rt := range routeMap {
+		route := *rt
+		routeEntries = append(routeEntries, &route)
+	}
+	sort.Slice(routeEntries, func(i, j int) bool {
+		return routeKey(routeEntries[i]) < routeKey(routeEntries[j])
+	})
+	return routeEntries
+}
@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// migration : one ordered step of the event_router_mapping schema
+type migration struct {
+	Version     int    `json:"version"`
+	Description string `json:"description"`
+	// skipIf returns a non-zero count when the change is already in place, for
+	// databases that were set up before schema_migrations existed.
+	skipIf     string
+	statements []string
+}
+
+// schemaStatus : applied and pending migrations, as reported on the admin endpoint
+type schemaStatus struct {
+	CurrentVersion int                `json:"currentVersion"`
+	LatestVersion  int                `json:"latestVersion"`
+	Applied        []appliedMigration `json:"applied"`
+	Pending        []migration        `json:"pending"`
+}
+
+type appliedMigration struct {
+	Version     int       `json:"version"`
+	Description string    `json:"description"`
+	AppliedAt   time.Time `json:"appliedAt"`
+}
+
+// schemaLockName : advisory lock serialising migrations across app instances
+const schemaLockName = "event_router_mapping.schema_migrations"
+
+// schemaLockTimeout : seconds an instance waits for another one to finish migrating
+const schemaLockTimeout = 60
+
+// migrateSchema : apply pending migrations in order. Runs on a single connection holding
+// an advisory lock, so concurrent instances starting together wait for each other.
+func (db *sqlDB) migrateSchema() error {
+	name := db.dialect.name
+	ctx := context.Background()
+	conn, err := db.conn.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: could not get a connection for migrations: %v", name, err)
+	}
+	defer conn.Close()
+
+	if err := db.dialect.lock(ctx, conn); err != nil {
+		return fmt.Errorf("%s: could not acquire migration lock: %v", name, err)
+	}
+	defer db.dialect.unlock(ctx, conn)
+
+	if _, err := conn.ExecContext(ctx, db.statement(db.dialect.migrationsTable)); err != nil {
+		return fmt.Errorf("%s: could not create schema_migrations: %v", name, err)
+	}
+	applied, err := db.appliedMigrations(ctx, conn)
+	if err != nil {
+		return err
+	}
+	done := make(map[int]bool)
+	for _, m := range applied {
+		done[m.Version] = true
+	}
+
+	for _, m := range db.dialect.migrations {
+		if done[m.Version] {
+			continue
+		}
+		if err := db.applyMigration(ctx, conn, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyMigration : run the statements of m and record it. Engines with transactional DDL do
+// both in one transaction, a migration failing halfway then leaves nothing to trip over on
+// the next start. MySQL commits each statement, its migrations rely on skipIf instead.
+func (db *sqlDB) applyMigration(ctx context.Context, conn *sql.Conn, m migration) error {
+	name := db.dialect.name
+	var runner migrationRunner = conn
+	var tx *sql.Tx
+	if db.dialect.transactionalDDL {
+		var err error
+		if tx, err = conn.BeginTx(ctx, nil); err != nil {
+			return fmt.Errorf("%s: could not start migration %d: %v", name, m.Version, err)
+		}
+		defer tx.Rollback()
+		runner = tx
+	}
+
+	var existing int
+	if m.skipIf != "" {
+		if err := runner.QueryRowContext(ctx, db.statement(m.skipIf)).Scan(&existing); err != nil {
+			return fmt.Errorf("%s: migration %d check failed: %v", name, m.Version, err)
+		}
+	}
+	if existing == 0 {
+		slog.Info("Applying schema migration", "version", m.Version, "description", m.Description)
+		for _, stmt := range m.statements {
+			if _, err := runner.ExecContext(ctx, db.statement(stmt)); err != nil {
+				return fmt.Errorf("%s: migration %d failed: %v", name, m.Version, err)
+			}
+		}
+	} else {
+		slog.Info("Schema migration already in place", "version", m.Version, "description", m.Description)
+	}
+	recordMigration := db.statement("INSERT INTO schema_migrations (version, description, appliedAt) VALUES (?, ?, ?)")
+	if _, err := runner.ExecContext(ctx, recordMigration, m.Version, m.Description, time.Now().UTC()); err != nil {
+		return fmt.Errorf("%s: could not record migration %d: %v", name, m.Version, err)
+	}
+	if tx != nil {
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("%s: could not commit migration %d: %v", name, m.Version, err)
+		}
+	}
+	return nil
+}
+
+// queryer is implemented by sql.DB and sql.Conn
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// migrationRunner is implemented by sql.Conn and sql.Tx
+type migrationRunner interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func (db *sqlDB) appliedMigrations(ctx context.Context, q queryer) ([]appliedMigration, error) {
+	rows, err := q.QueryContext(ctx, db.statement("SELECT version, description, appliedAt FROM schema_migrations ORDER BY version"))
+	if err != nil {
+		return nil, fmt.Errorf("database: could not read schema_migrations: %v", err)
+	}
+	defer rows.Close()
+
+	applied := []appliedMigration{}
+	for rows.Next() {
+		var m appliedMigration
+		if err := rows.Scan(&m.Version, &m.Description, &m.AppliedAt); err != nil {
+			return nil, fmt.Errorf("database: could not read schema_migrations: %v", err)
+		}
+		applied = append(applied, m)
+	}
+	return applied, rows.Err()
+}
+
+// schemaStatus : current schema version and the migrations not applied yet
+func (db *sqlDB) schemaStatus() (*schemaStatus, error) {
+	applied, err := db.appliedMigrations(context.Background(), db.conn)
+	if err != nil {
+		return nil, err
+	}
+	status := &schemaStatus{Applied: applied, Pending: []migration{}}
+	done := make(map[int]bool)
+	for _, m := range applied {
+		done[m.Version] = true
+		if m.Version > status.CurrentVersion {
+			status.CurrentVersion = m.Version
+		}
+	}
+	for _, m := range db.dialect.migrations {
+		if m.Version > status.LatestVersion {
+			status.LatestVersion = m.Version
+		}
+		if !done[m.Version] {
+			status.Pending = append(status.Pending, m)
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,205 @@
+package handlers
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"log/slog"
+
+	// importing mysql driver in conjunction with database/sql package
+	"github.com/go-sql-driver/mysql"
+)
+
+const (
+	// mysqlDuplicateEntry is the MySQL error number for a primary key violation.
+	mysqlDuplicateEntry = 1062
+	// mysqlDBAccessDenied and mysqlSpecificAccessDenied are returned when CREATE DATABASE is not granted.
+	mysqlDBAccessDenied       = 1044
+	mysqlSpecificAccessDenied = 1227
+	// mysqlDefaultPort is used when the binding does not name a port
+	mysqlDefaultPort = 3306
+	// mysqlTLSConfig : name the CA of the bound service is registered under with the driver
+	mysqlTLSConfig = "bound-service"
+)
+
+//createMappingDB : first time setup, the tables are created by the schema migrations
+const createMappingDB = "CREATE DATABASE IF NOT EXISTS `%s` DEFAULT CHARACTER SET = 'utf8' DEFAULT COLLATE 'utf8_general_ci'"
+
+var mysqlDialect = &sqlDialect{
+	name:       storageMySQL,
+	connect:    connectMySQL,
+	migrations: mysqlMigrations,
+	migrationsTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT NOT NULL,
+		description VARCHAR(255) NOT NULL,
+		appliedAt DATETIME NOT NULL,
+		PRIMARY KEY (version)
+	)`,
+	upsertStatement: `
+  INSERT INTO route_mapping (
+	  identifier, routeType, postURL, description)
+	  VALUES (?, ?, ?, ?)
+	  ON DUPLICATE KEY UPDATE
+	  postURL = VALUES(postURL), description = VALUES(description), version = version + 1`,
+	isDuplicate: func(err error) bool {
+		mysqlErr, ok := err.(*mysql.MySQLError)
+		return ok && mysqlErr.Number == mysqlDuplicateEntry
+	},
+	lock: func(ctx context.Context, conn *sql.Conn) error {
+		var locked sql.NullInt64
+		if err := conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", schemaLockName, schemaLockTimeout).Scan(&locked); err != nil {
+			return err
+		}
+		if locked.Int64 != 1 {
+			return fmt.Errorf("timed out waiting for the lock held by another instance")
+		}
+		return nil
+	},
+	unlock: func(ctx context.Context, conn *sql.Conn) {
+		conn.ExecContext(ctx, "SELECT RELEASE_LOCK(?)", schemaLockName)
+	},
+}
+
+// mysqlMigrations : append only, never edit a released migration
+var mysqlMigrations = []migration{
+	{
+		Version:     1,
+		Description: "create route_mapping",
+		skipIf:      `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() and table_name = 'route_mapping'`,
+		statements: []string{`CREATE TABLE route_mapping (
+			identifier VARCHAR(30) NOT NULL,
+			routeType VARCHAR(10) NOT NULL,
+			postURL VARCHAR(255) NOT NULL,
+			description TEXT NULL,
+			PRIMARY KEY (identifier, routeType)
+		)`},
+	},
+	{
+		Version:     2,
+		Description: "add version and updatedAt to route_mapping",
+		skipIf:      `SELECT COUNT(*) FROM information_schema.columns WHERE table_schema = DATABASE() and table_name = 'route_mapping' and column_name = 'version'`,
+		statements: []string{`ALTER TABLE route_mapping
+			ADD COLUMN version BIGINT NOT NULL DEFAULT 1,
+			ADD COLUMN updatedAt TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP`},
+	},
+	{
+		Version:     3,
+		Description: "create route_mapping_audit",
+		skipIf:      `SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() and table_name = 'route_mapping_audit'`,
+		statements: []string{`CREATE TABLE route_mapping_audit (
+			id BIGINT NOT NULL AUTO_INCREMENT,
+			identifier VARCHAR(30) NOT NULL,
+			routeType VARCHAR(10) NOT NULL,
+			action VARCHAR(10) NOT NULL,
+			actor VARCHAR(100) NOT NULL,
+			sourceIP VARCHAR(45) NOT NULL,
+			changedAt DATETIME(3) NOT NULL,
+			beforeValue TEXT NULL,
+			afterValue TEXT NULL,
+			PRIMARY KEY (id),
+			INDEX (identifier, routeType),
+			INDEX (changedAt)
+		)`},
+	},
+	{
+		Version:     4,
+		Description: "create route_mapping_generation",
+		statements: []string{`CREATE TABLE route_mapping_generation (
+			id INT NOT NULL,
+			generation BIGINT NOT NULL,
+			PRIMARY KEY (id)
+		)`,
+			`INSERT INTO route_mapping_generation (id, generation) VALUES (1, 0)`,
+		},
+	},
+}
+
+// dbConnectionString : Returns a connection string suitable for sql.Open
+func (config *DBConfig) dbConnectionString(schemaName string) string {
+	var returnString string
+	if config.Username != "" {
+		returnString = config.Username
+		if config.Password != "" {
+			returnString = returnString + ":" + config.Password
+		}
+		returnString = returnString + "@"
+	}
+	port := config.Port
+	if port == 0 {
+		port = mysqlDefaultPort
+	}
+	dsn := fmt.Sprintf("%stcp([%s]:%d)/%s?parseTime=true", returnString, config.Host, port, schemaName)
+	if config.CACert != "" {
+		dsn += "&tls=" + mysqlTLSConfig
+	}
+	return dsn
+}
+
+// registerTLS : make the CA of the bound service known to the driver, the DSN refers to it by name
+func (config *DBConfig) registerTLS() error {
+	if config.CACert == "" {
+		return nil
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(config.CACert)) {
+		return fmt.Errorf("mysql: the CA certificate of the bound service is not valid PEM")
+	}
+	return mysql.RegisterTLSConfig(mysqlTLSConfig, &tls.Config{RootCAs: roots, ServerName: config.Host})
+}
+
+// connectMySQL : create the schema if needed and open a pool to it
+func connectMySQL(config DBConfig) (*sql.DB, error) {
+	if err := config.registerTLS(); err != nil {
+		return nil, err
+	}
+	// Check database exists. If not, create it.
+	schema, err := config.ensureDatabaseExists()
+	if err != nil {
+		return nil, err
+	}
+	conn, err := sql.Open("mysql", config.dbConnectionString(schema))
+	if err != nil {
+		return nil, fmt.Errorf("mysql: could not get a connection: %v", err)
+	}
+	return conn, nil
+}
+
+// ensureDatabaseExists : create the configured schema, event_router_mapping by default, and return
+// the one to use. Shared plans rarely grant CREATE DATABASE, the tables then go into the
+// schema the service was provisioned with.
+func (config DBConfig) ensureDatabaseExists() (string, error) {
+	conn, err := sql.Open("mysql", config.dbConnectionString(""))
+	if err != nil {
+		return "", fmt.Errorf("mysql: could not get a connection: %v", err)
+	}
+	defer conn.Close()
+
+	if conn.Ping() == driver.ErrBadConn {
+		return "", fmt.Errorf("mysql: could not connect to the database. " +
+			"could be bad address, or this address is not whitelisted for access.")
+	}
+
+	schema := config.Schema
+	if schema == "" {
+		schema = mappingDBName
+	}
+	_, err = conn.Exec(fmt.Sprintf(createMappingDB, schema))
+	if err == nil {
+		return schema, nil
+	}
+	if mysqlErr, ok := err.(*mysql.MySQLError); ok &&
+		(mysqlErr.Number == mysqlDBAccessDenied || mysqlErr.Number == mysqlSpecificAccessDenied) {
+		switch {
+		case config.Schema != "":
+			slog.Warn("Not permitted to create schema, assuming it exists", "schema", schema)
+			return schema, nil
+		case config.Database != "":
+			slog.Warn("Not permitted to create schema, using the database of the bound service", "schema", schema, "database", config.Database)
+			return config.Database, nil
+		}
+	}
+	return "", fmt.Errorf("mysql: could not create %s DB: %v", schema, err)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+
+	// importing postgres driver in conjunction with database/sql package
+	"github.com/lib/pq"
+)
+
+const (
+	// postgresUniqueViolation is the SQLSTATE for a primary key violation.
+	postgresUniqueViolation = "23505"
+	// postgresDefaultPort is used when the binding does not name a port
+	postgresDefaultPort = 5432
+	// postgresLockKey : pg_advisory_lock key serialising migrations across app instances
+	postgresLockKey = 7318201907
+)
+
+var postgresDialect = &sqlDialect{
+	name:       storagePostgres,
+	connect:    connectPostgres,
+	migrations: postgresMigrations,
+	migrationsTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT NOT NULL,
+		description VARCHAR(255) NOT NULL,
+		appliedAt TIMESTAMPTZ NOT NULL,
+		PRIMARY KEY (version)
+	)`,
+	upsertStatement: `
+  INSERT INTO route_mapping (
+	  identifier, routeType, postURL, description)
+	  VALUES (?, ?, ?, ?)
+	  ON CONFLICT (identifier, routeType) DO UPDATE SET
+	  postURL = EXCLUDED.postURL, description = EXCLUDED.description,
+	  version = route_mapping.version + 1, updatedAt = CURRENT_TIMESTAMP`,
+	numberedParams: true,
+	isDuplicate: func(err error) bool {
+		pqErr, ok := err.(*pq.Error)
+		return ok && pqErr.Code == postgresUniqueViolation
+	},
+	transactionalDDL: true,
+	lock: func(ctx context.Context, conn *sql.Conn) error {
+		_, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", postgresLockKey)
+		return err
+	},
+	unlock: func(ctx context.Context, conn *sql.Conn) {
+		conn.ExecContext(ctx, "SELECT pg_advisory_unlock($1)", postgresLockKey)
+	},
+}
+
+// postgresMigrations : kept in step with mysqlMigrations, append only
+var postgresMigrations = []migration{
+	{
+		Version:     1,
+		Description: "create route_mapping",
+		statements: []string{`CREATE TABLE route_mapping (
+			identifier VARCHAR(30) NOT NULL,
+			routeType VARCHAR(10) NOT NULL,
+			postURL VARCHAR(255) NOT NULL,
+			description TEXT NULL,
+			PRIMARY KEY (identifier, routeType)
+		)`},
+	},
+	{
+		Version:     2,
+		Description: "add version and updatedAt to route_mapping",
+		statements: []string{`ALTER TABLE route_mapping
+			ADD COLUMN version BIGINT NOT NULL DEFAULT 1,
+			ADD COLUMN updatedAt TIMESTAMPTZ NOT NULL DEFAULT CURRENT_TIMESTAMP`},
+	},
+	{
+		Version:     3,
+		Description: "create route_mapping_audit",
+		statements: []string{`CREATE TABLE route_mapping_audit (
+			id BIGSERIAL NOT NULL,
+			identifier VARCHAR(30) NOT NULL,
+			routeType VARCHAR(10) NOT NULL,
+			action VARCHAR(10) NOT NULL,
+			actor VARCHAR(100) NOT NULL,
+			sourceIP VARCHAR(45) NOT NULL,
+			changedAt TIMESTAMPTZ NOT NULL,
+			beforeValue TEXT NULL,
+			afterValue TEXT NULL,
+			PRIMARY KEY (id)
+		)`,
+			`CREATE INDEX route_mapping_audit_route ON route_mapping_audit (identifier, routeType)`,
+			`CREATE INDEX route_mapping_audit_changed ON route_mapping_audit (changedAt)`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "create route_mapping_generation",
+		statements: []string{`CREATE TABLE route_mapping_generation (
+			id INT NOT NULL,
+			generation BIGINT NOT NULL,
+			PRIMARY KEY (id)
+		)`,
+			`INSERT INTO route_mapping_generation (id, generation) VALUES (1, 0)`,
+		},
+	},
+}
+
+// postgresConnectionString : Returns a postgres:// URL suitable for sql.Open
+func (config *DBConfig) postgresConnectionString() string {
+	port := config.Port
+	if port == 0 {
+		port = postgresDefaultPort
+	}
+	database := config.Database
+	if database == "" {
+		database = mappingDBName
+	}
+	sslMode := config.SSLMode
+	if sslMode == "" {
+		sslMode = "disable"
+	}
+	params := url.Values{"sslmode": {sslMode}}
+	if config.CACert != "" {
+		// The certificate is passed inline rather than as a file path
+		if sslMode == "disable" || sslMode == "require" {
+			params.Set("sslmode", "verify-ca")
+		}
+		params.Set("sslinline", "true")
+		params.Set("sslrootcert", config.CACert)
+	}
+	dsn := url.URL{
+		Scheme:   "postgres",
+		Host:     net.JoinHostPort(config.Host, strconv.Itoa(port)),
+		Path:     "/" + database,
+		RawQuery: params.Encode(),
+	}
+	if config.Username != "" {
+		dsn.User = url.UserPassword(config.Username, config.Password)
+	}
+	return dsn.String()
+}
+
+// connectPostgres : open a pool to the bound database. Unlike MySQL the database
+// itself is never created, managed Postgres plans hand out a ready database.
+func connectPostgres(config DBConfig) (*sql.DB, error) {
+	conn, err := sql.Open("postgres", config.postgresConnectionString())
+	if err != nil {
+		return nil, fmt.Errorf("postgres: could not get a connection: %v", err)
+	}
+	return conn, nil
+}
@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+const (
+	// dbHealthInterval : how often the database is probed, also the Retry-After of rejected writes
+	dbHealthInterval = 10 * time.Second
+	// dbPingTimeout : a probe taking longer counts as the database being down
+	dbPingTimeout = 3 * time.Second
+	// defaultStartupTimeout : stays within the 60 second start timeout of cf push
+	defaultStartupTimeout = 45 * time.Second
+	// maxConnectBackoff caps the wait between startup connection attempts
+	maxConnectBackoff = 10 * time.Second
+	// defaultCacheTTL : cached mappings are reloaded at least this often
+	defaultCacheTTL = 5 * time.Minute
+)
+
+//resilientDB : wraps a MySQL or PostgreSQL database with a read-through cache of route_mapping.
+//The cache is reloaded after every change, when it expires and when the generation row shows
+//another instance changed a mapping. While the database cannot be reached, lookups are served
+//from the cache and writes fail with errDatabaseUnavailable.
+type resilientDB struct {
+	db *sqlDB
+	// ttl bounds the age of the cache, pollInterval is how often the generation and health are checked
+	ttl, pollInterval time.Duration
+
+	mu         sync.RWMutex
+	cache      map[string]*routes
+	generation int64
+	loadedAt   time.Time
+	down       bool
+	downSince  time.Time
+	lastError  string
+	// epoch is bumped by every reload and write, a route read on a miss is only cached when it did not move
+	epoch int64
+
+	// refreshMu keeps concurrent lookups from reloading an expired cache more than once
+	refreshMu    sync.Mutex
+	hits, misses atomic.Int64
+
+	stop chan struct{}
+}
+
+//mappingObject : Ensure resilientDB conforms to the interface.
+var _ mappingDatabase = &resilientDB{}
+
+// cacheStats : content and effectiveness of the mapping cache, as reported on the admin endpoint
+type cacheStats struct {
+	Entries    int       `json:"entries"`
+	Hits       int64     `json:"hits"`
+	Misses     int64     `json:"misses"`
+	Generation int64     `json:"generation"`
+	LoadedAt   time.Time `json:"loadedAt"`
+	TTL        string    `json:"ttl"`
+}
+
+// dbHealth : database state as reported on the readiness endpoint
+type dbHealth struct {
+	Status       string     `json:"status"`
+	Since        *time.Time `json:"since,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	CachedRoutes int        `json:"cachedRoutes,omitempty"`
+}
+
+// connectWithRetry : open the database, retrying with exponential backoff until timeout
+// so a database that is briefly unavailable does not crash-loop the app
+func connectWithRetry(config DBConfig, timeout time.Duration) (*sqlDB, error) {
+	if timeout <= 0 {
+		timeout = defaultStartupTimeout
+	}
+	deadline := time.Now().Add(timeout)
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		db, err := newDBConnection(config)
+		if err == nil {
+			return db, nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, fmt.Errorf("%v (gave up after %d attempts)", err, attempt)
+		}
+		slog.Warn("Database connection attempt failed, retrying", "attempt", attempt, "backoff", backoff.String(), "error", err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxConnectBackoff {
+			backoff = maxConnectBackoff
+		}
+	}
+}
+
+// newResilientDB : load the cache and start polling the database in the background
+func newResilientDB(db *sqlDB, ttl, pollInterval time.Duration) (*resilientDB, error) {
+	if ttl <= 0 {
+		ttl = defaultCacheTTL
+	}
+	if pollInterval <= 0 {
+		pollInterval = dbHealthInterval
+	}
+	rd := &resilientDB{db: db, ttl: ttl, pollInterval: pollInterval, stop: make(chan struct{})}
+	if err := rd.refresh(); err != nil {
+		return nil, err
+	}
+	go rd.monitor()
+	return rd, nil
+}
+
+// refresh : replace the cache with the current content of route_mapping. The generation is read
+// first, a change made while listing then still shows up as a newer generation on the next poll.
+func (rd *resilientDB) refresh() error {
+	rd.refreshMu.Lock()
+	defer rd.refreshMu.Unlock()
+	return rd.reload()
+}
+
+// refreshIfExpired : reload the cache unless another lookup already did while this one waited
+func (rd *resilientDB) refreshIfExpired() error {
+	rd.refreshMu.Lock()
+	defer rd.refreshMu.Unlock()
+	if !rd.expired() {
+		return nil
+	}
+	return rd.reload()
+}
+
+// reload : callers hold refreshMu
+func (rd *resilientDB) reload() error {
+	generation, err := rd.db.generation(context.Background())
+	if err != nil {
+		return err
+	}
+	routeEntries, err := rd.db.listRoutes()
+	if err != nil {
+		return err
+	}
+	cache := make(map[string]*routes, len(routeEntries))
+	for _, rt := range routeEntries {
+		route := *rt
+		cache[routeKey(rt)] = &route
+	}
+	rd.mu.Lock()
+	rd.cache, rd.generation, rd.loadedAt = cache, generation, time.Now()
+	rd.epoch++
+	rd.mu.Unlock()
+	return nil
+}
+
+// monitor : poll the generation row until closed, reloading the cache when another instance
+// changed a mapping or the database comes back. A failing poll marks the database down.
+func (rd *resilientDB) monitor() {
+	ticker := time.NewTicker(rd.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-rd.stop:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), dbPingTimeout)
+			generation, err := rd.db.generation(ctx)
+			cancel()
+			if err == nil && (rd.isDown() || generation != rd.cachedGeneration()) {
+				err = rd.refresh()
+			}
+			rd.setState(err)
+		}
+	}
+}
+
+func (rd *resilientDB) cachedGeneration() int64 {
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	return rd.generation
+}
+
+func (rd *resilientDB) expired() bool {
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	return time.Since(rd.loadedAt) > rd.ttl
+}
+
+// cached : a copy of the cached route, if any
+func (rd *resilientDB) cached(identifier string, routeType string) (*routes, bool) {
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	rt, found := rd.cache[identifier+"/"+routeType]
+	if !found {
+		return nil, false
+	}
+	route := *rt
+	return &route, true
+}
+
+func (rd *resilientDB) cachedEpoch() int64 {
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	return rd.epoch
+}
+
+// store : add a route read on a cache miss, unless the cache was reloaded or a mapping written
+// since epoch was taken before the read. It may have been deleted meanwhile, and caching it
+// again would keep alerts going to a removed destination until the next reload.
+func (rd *resilientDB) store(rt *routes, epoch int64) {
+	route := *rt
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	if rd.epoch != epoch {
+		return
+	}
+	rd.cache[routeKey(rt)] = &route
+}
+
+func (rd *resilientDB) ping() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbPingTimeout)
+	defer cancel()
+	return rd.db.conn.PingContext(ctx)
+}
+
+// setState : mark the database up when err is nil, down otherwise
+func (rd *resilientDB) setState(err error) {
+	rd.mu.Lock()
+	defer rd.mu.Unlock()
+	switch {
+	case err == nil && rd.down:
+		slog.Info("Database is reachable again, mappings are writable", "downtime", time.Since(rd.downSince).Round(time.Second).String())
+		rd.down, rd.lastError = false, ""
+	case err != nil && !rd.down:
+		slog.Error("Database is unreachable, serving cached mappings read-only", "cached_routes", len(rd.cache), "error", err)
+		rd.down, rd.downSince, rd.lastError = true, time.Now().UTC(), err.Error()
+	case err != nil:
+		rd.lastError = err.Error()
+	}
+}
+
+func (rd *resilientDB) isDown() bool {
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	return rd.down
+}
+
+// failed : decide whether err means the database is gone. Errors of a reachable database are left as they are.
+func (rd *resilientDB) failed(err error) error {
+	switch err {
+	case nil, errRouteNotFound, errRouteExists, errVersionConflict:
+		return err
+	}
+	if pingErr := rd.ping(); pingErr != nil {
+		rd.setState(pingErr)
+		return errDatabaseUnavailable
+	}
+	return err
+}
+
+// health : current state for the readiness endpoint
+func (rd *resilientDB) health() dbHealth {
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	health := dbHealth{Status: "up", CachedRoutes: len(rd.cache)}
+	if rd.down {
+		since := rd.downSince
+		health.Status, health.Since, health.Error = "down", &since, rd.lastError
+	}
+	return health
+}
+
+// ListRoutes returns a list of mapping records, from the cache while the database is down.
+func (rd *resilientDB) listRoutes() ([]*routes, error) {
+	// Once known to be down, the monitor finds out when it is back, lookups do not wait on it
+	if !rd.isDown() {
+		routeEntries, err := rd.db.listRoutes()
+		if err == nil {
+			return routeEntries, nil
+		}
+		if rd.failed(err) != errDatabaseUnavailable {
+			return nil, err
+		}
+	}
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	return sortedCopies(rd.cache), nil
+}
+
+// GetRoute retrieves a Route by its identifier. Served from the cache unless it expired or
+// does not know the route yet, and regardless of its age while the database is down.
+func (rd *resilientDB) getRoute(ctx context.Context, identifier string, routeType string) (*routes, error) {
+	ctx, span := tracer.Start(ctx, "mapping.lookup")
+	defer span.End()
+	if rd.expired() && !rd.isDown() {
+		// One query reloads every mapping, cheaper than a miss per identifier
+		if err := rd.failed(rd.refreshIfExpired()); err != nil && err != errDatabaseUnavailable {
+			slog.Warn("Unable to refresh the mapping cache", "error", err)
+		}
+	}
+	if route, found := rd.cached(identifier, routeType); found {
+		span.SetAttributes(attribute.Bool("cache.hit", true))
+		rd.hits.Add(1)
+		return route, nil
+	}
+	if rd.isDown() {
+		return nil, errRouteNotFound
+	}
+	span.SetAttributes(attribute.Bool("cache.hit", false))
+	rd.misses.Add(1)
+	epoch := rd.cachedEpoch()
+	route, err := rd.db.getRoute(ctx, identifier, routeType)
+	if err == nil {
+		rd.store(route, epoch)
+	}
+	if err = rd.failed(err); err == errDatabaseUnavailable {
+		return nil, errRouteNotFound
+	}
+	return route, err
+}
+
+// LatestRoute reads a Route from the database, as the cache can miss a change made through another
+// instance until the next poll. The cache only answers while the database is down.
+func (rd *resilientDB) latestRoute(ctx context.Context, identifier string, routeType string) (*routes, error) {
+	if !rd.isDown() {
+		epoch := rd.cachedEpoch()
+		route, err := rd.db.getRoute(ctx, identifier, routeType)
+		if err == nil {
+			rd.store(route, epoch)
+		}
+		if err = rd.failed(err); err != errDatabaseUnavailable {
+			return route, err
+		}
+	}
+	if route, found := rd.cached(identifier, routeType); found {
+		return route, nil
+	}
+	return nil, errRouteNotFound
+}
+
+// stats : counters and age of the cache
+func (rd *resilientDB) stats() cacheStats {
+	rd.mu.RLock()
+	defer rd.mu.RUnlock()
+	return cacheStats{
+		Entries:    len(rd.cache),
+		Hits:       rd.hits.Load(),
+		Misses:     rd.misses.Load(),
+		Generation: rd.generation,
+		LoadedAt:   rd.loadedAt.UTC(),
+		TTL:        rd.ttl.String(),
+	}
+}
+
+// write : run a change unless the database is known to be down, refreshing the cache after it
+func (rd *resilientDB) write(change func() error) error {
+	if rd.isDown() {
+		return errDatabaseUnavailable
+	}
+	err := rd.failed(change())
+	// Even a failed change may have gone through, lookups in flight must not cache what they read
+	rd.mu.Lock()
+	rd.epoch++
+	rd.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	if err := rd.refresh(); err != nil {
+		slog.Warn("Unable to refresh the mapping cache", "error", err)
+	}
+	return nil
+}
+
+// AddRoute saves a new Route mapping.
+func (rd *resilientDB) addRoute(rt *routes) error {
+	return rd.write(func() error { return rd.db.addRoute(rt) })
+}
+
+// UpdateRoute overwrites a Route mapping if nobody changed it since the given version was read.
+func (rd *resilientDB) updateRoute(rt *routes, version int64) error {
+	return rd.write(func() error { return rd.db.updateRoute(rt, version) })
+}
+
+// DeleteRoute : removes a given route by its identifier.
+func (rd *resilientDB) deleteRoute(identifier string, routeType string) error {
+	return rd.write(func() error { return rd.db.deleteRoute(identifier, routeType) })
+}
+
+// ApplyRoutes upserts and deletes route mappings in one transaction.
+func (rd *resilientDB) applyRoutes(upserts []*routes, deletes []*routes) error {
+	return rd.write(func() error { return rd.db.applyRoutes(upserts, deletes) })
+}
+
+// RecordAudit appends a change to the audit trail.
+func (rd *resilientDB) recordAudit(rec *auditRecord) error {
+	return rd.failed(rd.db.recordAudit(rec))
+}
+
+// ListAudit returns the audit records matching filter, newest first.
+func (rd *resilientDB) listAudit(filter auditFilter) ([]*auditRecord, error) {
+	records, err := rd.db.listAudit(filter)
+	return records, rd.failed(err)
+}
+
+// Close stops the health probe and closes the database.
+func (rd *resilientDB) close() {
+	close(rd.stop)
+	rd.db.close()
+}
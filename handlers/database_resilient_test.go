@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestMissRacingDelete : a lookup reading a mapping just before it is deleted does not put it back in the cache
+func TestMissRacingDelete(t *testing.T) {
+	db, err := newDBConnection(DBConfig{Driver: storageSQLite, Database: filepath.Join(t.TempDir(), "mappings.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := newResilientDB(db, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cached.close()
+	if err := db.addRoute(&routes{Identifier: "app1", RouteType: teamsType, PostURL: "https://example.webhook.office.com/a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lookup missed and read the mapping, the delete lands before it is cached
+	epoch := cached.cachedEpoch()
+	route, err := db.getRoute(context.Background(), "app1", teamsType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cached.deleteRoute("app1", teamsType); err != nil {
+		t.Fatal(err)
+	}
+	cached.store(route, epoch)
+
+	if _, found := cached.cached("app1", teamsType); found {
+		t.Error("deleted mapping cached again")
+	}
+	if _, err := cached.getRoute(context.Background(), "app1", teamsType); err != errRouteNotFound {
+		t.Errorf("lookup after the delete: got %v, want %v", err, errRouteNotFound)
+	}
+}
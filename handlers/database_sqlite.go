@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+
+	// pure-Go SQLite driver (no cgo) in conjunction with database/sql package
+	"github.com/ncruces/go-sqlite3"
+	_ "github.com/ncruces/go-sqlite3/driver"
+	_ "github.com/ncruces/go-sqlite3/embed"
+)
+
+// sqliteDefaultPath is used when sqlite_path is not configured
+const sqliteDefaultPath = "event_router_mapping.db"
+
+var sqliteDialect = &sqlDialect{
+	name:       storageSQLite,
+	connect:    connectSQLite,
+	migrations: sqliteMigrations,
+	migrationsTable: `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER NOT NULL PRIMARY KEY,
+		description TEXT NOT NULL,
+		appliedAt DATETIME NOT NULL
+	)`,
+	upsertStatement: `
+  INSERT INTO route_mapping (
+	  identifier, routeType, postURL, description)
+	  VALUES (?, ?, ?, ?)
+	  ON CONFLICT (identifier, routeType) DO UPDATE SET
+	  postURL = excluded.postURL, description = excluded.description,
+	  version = route_mapping.version + 1, updatedAt = CURRENT_TIMESTAMP`,
+	isDuplicate: func(err error) bool {
+		var sqliteErr *sqlite3.Error
+		return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode() == sqlite3.CONSTRAINT_PRIMARYKEY
+	},
+	transactionalDDL: true,
+	// A SQLite file serves a single app instance, there is nobody to race with.
+	lock:   func(ctx context.Context, conn *sql.Conn) error { return nil },
+	unlock: func(ctx context.Context, conn *sql.Conn) {},
+}
+
+// sqliteMigrations : kept in step with mysqlMigrations, append only. SQLite cannot add
+// a column defaulting to CURRENT_TIMESTAMP, so route_mapping starts out with all columns.
+var sqliteMigrations = []migration{
+	{
+		Version:     1,
+		Description: "create route_mapping",
+		statements: []string{`CREATE TABLE route_mapping (
+			identifier VARCHAR(30) NOT NULL,
+			routeType VARCHAR(10) NOT NULL,
+			postURL VARCHAR(255) NOT NULL,
+			description TEXT NULL,
+			version INTEGER NOT NULL DEFAULT 1,
+			updatedAt DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (identifier, routeType)
+		)`},
+	},
+	{
+		Version:     2,
+		Description: "add version and updatedAt to route_mapping",
+	},
+	{
+		Version:     3,
+		Description: "create route_mapping_audit",
+		statements: []string{`CREATE TABLE route_mapping_audit (
+			id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+			identifier VARCHAR(30) NOT NULL,
+			routeType VARCHAR(10) NOT NULL,
+			action VARCHAR(10) NOT NULL,
+			actor VARCHAR(100) NOT NULL,
+			sourceIP VARCHAR(45) NOT NULL,
+			changedAt DATETIME NOT NULL,
+			beforeValue TEXT NULL,
+			afterValue TEXT NULL
+		)`,
+			`CREATE INDEX route_mapping_audit_route ON route_mapping_audit (identifier, routeType)`,
+			`CREATE INDEX route_mapping_audit_changed ON route_mapping_audit (changedAt)`,
+		},
+	},
+	{
+		Version:     4,
+		Description: "create route_mapping_generation",
+		statements: []string{`CREATE TABLE route_mapping_generation (
+			id INT NOT NULL,
+			generation BIGINT NOT NULL,
+			PRIMARY KEY (id)
+		)`,
+			`INSERT INTO route_mapping_generation (id, generation) VALUES (1, 0)`,
+		},
+	},
+}
+
+// connectSQLite : open, and create if needed, the database file at config.Database
+func connectSQLite(config DBConfig) (*sql.DB, error) {
+	path := config.Database
+	if path == "" {
+		path = sqliteDefaultPath
+	}
+	params := url.Values{"_pragma": {"busy_timeout(5000)", "journal_mode(wal)"}}
+	conn, err := sql.Open("sqlite3", "file:"+path+"?"+params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("sqlite: could not open %s: %v", path, err)
+	}
+	// SQLite allows one writer at a time, a single connection avoids SQLITE_BUSY.
+	conn.SetMaxOpenConns(1)
+	return conn, nil
+}
@@ -1,30 +1,121 @@
 package handlers
 
-//MySQLConfig connection construct information for MySQL DB Connection
-type MySQLConfig struct {
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//DBConfig connection construct information for the MySQL, PostgreSQL or SQLite mapping database
+type DBConfig struct {
+	// Driver is mysql or postgres, as detected from the bound service. Optional.
+	Driver string
 	// Optional.
 	Username, Password string
-	// Host of the MySQL instance.
+	// Host of the database instance.
 	Host string
-	// Port of the MySQL instance.
+	// Port of the database instance. Defaults to the engine's standard port.
 	Port int
+	// Database to connect to, or the file for SQLite. MySQL only falls back to it, see Schema.
+	Database string
+	// Schema holding the MySQL tables, event_router_mapping when empty. The bound Database is
+	// used instead when creating event_router_mapping is not permitted.
+	Schema string
+	// TablePrefix is prepended to every table name. Optional.
+	TablePrefix string
+	// SSLMode for PostgreSQL connections, e.g. disable or require.
+	SSLMode string
+	// CACert is the PEM CA certificate of the bound service. Connections are verified against it when set.
+	CACert string
+	// Connection pool limits, the database/sql defaults apply when zero.
+	MaxOpenConns, MaxIdleConns int
+	ConnMaxLifetime            time.Duration
 }
 
+var (
+	// errRouteNotFound is returned when no mapping exists for an identifier and type.
+	errRouteNotFound = errors.New("route mapping not found")
+	// errRouteExists is returned when a mapping is inserted twice.
+	errRouteExists = errors.New("route mapping already exists")
+	// errVersionConflict is returned when a mapping changed since it was read.
+	errVersionConflict = errors.New("route mapping was modified by another request")
+	// errDatabaseUnavailable is returned for writes while the database cannot be reached.
+	errDatabaseUnavailable = errors.New("database is unavailable")
+)
+
 // MappingDatabase provides thread-safe access to a database of mapping records.
 type mappingDatabase interface {
 	// ListRoutes returns a list of all available route mapping
 	listRoutes() ([]*routes, error)
 
 	// GetRoute retrieves a route by its identifier and type.
-	getRoute(string, string) (*routes, error)
+	getRoute(context.Context, string, string) (*routes, error)
+
+	// LatestRoute retrieves a route bypassing any cache, for the version a change is checked against.
+	latestRoute(context.Context, string, string) (*routes, error)
 
 	// AddRoute saves a new route
 	addRoute(rt *routes) error
 
+	// UpdateRoute overwrites an existing route, provided it is still at the given version.
+	updateRoute(rt *routes, version int64) error
+
 	// DeleteRoute removes a given route by its identifier and type.
 	deleteRoute(string, string) error
 
+	// ApplyRoutes upserts and deletes the given routes in a single transaction.
+	applyRoutes(upserts []*routes, deletes []*routes) error
+
+	// RecordAudit appends a change to the audit trail.
+	recordAudit(rec *auditRecord) error
+
+	// ListAudit returns audit records matching the filter, newest first.
+	listAudit(filter auditFilter) ([]*auditRecord, error)
+
 	// close closes the database, freeing up any available resources.
 	// TODO: close() should return an error.
 	close()
 }
+
+// sqlDialect : the parts of the SQL mapping store that differ between database engines
+type sqlDialect struct {
+	// name of the engine, also used as the database/sql driver name
+	name string
+	// connect opens a pool to the mapping database, creating the database if needed
+	connect func(config DBConfig) (*sql.DB, error)
+	// migrations brings the schema up to date, in order
+	migrations      []migration
+	migrationsTable string
+	// upsertStatement inserts a route or updates it when it already exists
+	upsertStatement string
+	// numberedParams is set for engines using $1, $2 instead of ? placeholders
+	numberedParams bool
+	// isDuplicate reports a primary key violation
+	isDuplicate func(err error) bool
+	// transactionalDDL is set for engines rolling back schema changes with the transaction
+	transactionalDDL bool
+	// lock and unlock hold an advisory lock on conn while migrating
+	lock   func(ctx context.Context, conn *sql.Conn) error
+	unlock func(ctx context.Context, conn *sql.Conn)
+}
+
+// rebind : rewrite ? placeholders for engines using numbered parameters
+func (dialect *sqlDialect) rebind(query string) string {
+	if !dialect.numberedParams {
+		return query
+	}
+	var sb strings.Builder
+	n := 0
+	for _, ch := range query {
+		if ch == '?' {
+			n++
+			sb.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		sb.WriteRune(ch)
+	}
+	return sb.String()
+}
@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"log/slog"
+	"net"
+	"net/url"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tushardag/pcf-eventalert-integration/helpers"
+)
+
+const (
+	// defaultDestinationInterval : how often destination hosts are probed when enabled
+	defaultDestinationInterval = 5 * time.Minute
+	// destinationDialTimeout : a host not accepting a connection within this counts as unreachable
+	destinationDialTimeout = 3 * time.Second
+)
+
+// destinationCheck : outcome of the last connection attempt to one destination host
+type destinationCheck struct {
+	Host      string    `json:"host"`
+	Reachable bool      `json:"reachable"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+// destinationProbe : cached reachability of the Teams and PagerDuty hosts in use. Probing runs in
+// the background, readiness requests only ever read the cache.
+type destinationProbe struct {
+	mu      sync.RWMutex
+	results []destinationCheck
+}
+
+// watchDestinations : probe the destination hosts while health.check_destinations is on.
+// The setting is read on every round, so it can be switched by a config reload.
+func (rh *RequestHandler) watchDestinations() {
+	for {
+		settings := rh.applConfig().Health
+		interval := settings.DestinationInterval
+		if interval <= 0 {
+			interval = defaultDestinationInterval
+		}
+		if settings.CheckDestinations {
+			rh.destinations.probe(rh.destinationHosts())
+		} else {
+			rh.destinations.set(nil)
+		}
+		time.Sleep(interval)
+	}
+}
+
+// destinationHosts : host:port of every configured Teams webhook, and of PagerDuty when used
+func (rh *RequestHandler) destinationHosts() []string {
+	routeEntries, err := rh.dbConn.listRoutes()
+	if err != nil {
+		slog.Warn("Unable to list the destinations to probe", "error", err)
+		return nil
+	}
+	hosts := make(map[string]bool)
+	for _, rt := range routeEntries {
+		endpoint := rt.PostURL
+		if rt.RouteType == pagerdutyType {
+			endpoint = helpers.PagerDutyURL
+		}
+		if host := dialAddress(endpoint); host != "" {
+			hosts[host] = true
+		}
+	}
+	sorted := make([]string, 0, len(hosts))
+	for host := range hosts {
+		sorted = append(sorted, host)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
+
+// dialAddress : host:port of an http(s) URL, empty when it has no host
+func dialAddress(endpoint string) string {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	port := u.Port()
+	if port == "" {
+		port = "443"
+		if u.Scheme == "http" {
+			port = "80"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
+
+// probe : open a TCP connection to every host, concurrently
+func (p *destinationProbe) probe(hosts []string) {
+	results := make([]destinationCheck, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func(i int, host string) {
+			defer wg.Done()
+			check := destinationCheck{Host: host, Reachable: true, CheckedAt: time.Now().UTC()}
+			conn, err := net.DialTimeout("tcp", host, destinationDialTimeout)
+			if err != nil {
+				check.Reachable, check.Error = false, err.Error()
+			} else {
+				conn.Close()
+			}
+			results[i] = check
+		}(i, host)
+	}
+	wg.Wait()
+	p.set(results)
+}
+
+func (p *destinationProbe) set(results []destinationCheck) {
+	p.mu.Lock()
+	p.results = results
+	p.mu.Unlock()
+}
+
+// checks : the cached results, nil when probing is off
+func (p *destinationProbe) checks() []destinationCheck {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.results
+}
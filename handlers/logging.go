@@ -0,0 +1,160 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	// requestIDHeader : correlation ID taken from the caller or assigned, and echoed in the response
+	requestIDHeader = "X-Request-ID"
+	// maxRequestIDLength : longer incoming IDs are replaced, they end up in every log line
+	maxRequestIDLength = 128
+
+	logFormatJSON = "json"
+	logFormatText = "text"
+)
+
+// logLevel is shared by every handler installed by configureLogging, a reload changes it in place
+var logLevel = new(slog.LevelVar)
+
+type logFieldsKey struct{}
+
+// contextHandler : adds the fields stored in the context by withLogFields, and the active trace, to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, rec slog.Record) error {
+	if fields, ok := ctx.Value(logFieldsKey{}).([]slog.Attr); ok {
+		rec.AddAttrs(fields...)
+	}
+	if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+		rec.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+	}
+	return h.Handler.Handle(ctx, rec)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+//ConfigureLogging : install the default logger, as JSON or text lines on stdout at the given
+//level. Used by main before application.yml is read, the logging block applies afterwards.
+func ConfigureLogging(format string, level string) error {
+	return configureLogging(os.Stdout, format, level)
+}
+
+func configureLogging(out io.Writer, format string, level string) error {
+	lvl, err := parseLogLevel(level)
+	if err != nil {
+		return err
+	}
+	handler, err := newLogHandler(out, format)
+	if err != nil {
+		return err
+	}
+	logLevel.Set(lvl)
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+// apply : install the logging block of application.yml, json at info level when empty
+func (settings loggingSettings) apply() error {
+	return configureLogging(os.Stdout, settings.Format, settings.Level)
+}
+
+// parseLogLevel : debug, info, warn or error, info when empty
+func parseLogLevel(level string) (slog.Level, error) {
+	var lvl slog.Level
+	if level != "" {
+		if err := lvl.UnmarshalText([]byte(level)); err != nil {
+			return lvl, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", level)
+		}
+	}
+	return lvl, nil
+}
+
+// newLogHandler : json or text lines written to out, json when empty
+func newLogHandler(out io.Writer, format string) (slog.Handler, error) {
+	options := &slog.HandlerOptions{Level: logLevel}
+	switch strings.ToLower(format) {
+	case "", logFormatJSON:
+		return slog.NewJSONHandler(out, options), nil
+	case logFormatText:
+		return slog.NewTextHandler(out, options), nil
+	}
+	return nil, fmt.Errorf("unknown log format %q, expected json or text", format)
+}
+
+// withLogFields : context whose log lines carry the given fields on top of those it already has
+func withLogFields(ctx context.Context, fields ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(logFieldsKey{}).([]slog.Attr)
+	combined := make([]slog.Attr, 0, len(existing)+len(fields))
+	combined = append(append(combined, existing...), fields...)
+	return context.WithValue(ctx, logFieldsKey{}, combined)
+}
+
+// statusRecorder : remembers the status written by a handler for the access log line
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+//RequestID : middleware assigning each request an X-Request-ID, or keeping the caller's, and
+//logging one line per request. Log lines written with the request context carry the ID.
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := withLogFields(r.Context(), slog.String("request_id", id))
+
+		start := time.Now()
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(ctx))
+		slog.InfoContext(ctx, "request handled",
+			"method", r.Method, "path", r.URL.Path, "status", recorder.status,
+			"duration_ms", time.Since(start).Milliseconds())
+	})
+}
+
+// validRequestID : printable ASCII without spaces, of a sensible length
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c <= ' ' || c > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,186 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/tushardag/pcf-eventalert-integration/helpers"
+)
+
+const (
+	// metricsNamespace : prefix of every metric name
+	metricsNamespace = "eventalert"
+	// defaultMaxIdentifierLabels : distinct identifiers labelled before the rest are counted as other
+	defaultMaxIdentifierLabels = 50
+	// Identifier label values used instead of the identifier itself
+	otherIdentifier    = "other"
+	unmappedIdentifier = "unmapped"
+)
+
+// Outcomes of a received alert, the status label of alerts_received_total
+const (
+	alertDelivered = "delivered"
+	alertFailed    = "failed"
+	alertUnmapped  = "unmapped"
+	alertInvalid   = "invalid"
+	alertRejected  = "rejected"
+	alertSpooled   = "spooled"
+)
+
+//appMetrics : Prometheus collectors, on a registry of their own
+type appMetrics struct {
+	registry *prometheus.Registry
+
+	alertsReceived   *prometheus.CounterVec
+	parseErrors      *prometheus.CounterVec
+	deliveries       *prometheus.CounterVec
+	handlingDuration *prometheus.HistogramVec
+	deliveryDuration *prometheus.HistogramVec
+	inFlight         prometheus.Gauge
+	// pending mirrors inFlight for the readiness endpoint
+	pending atomic.Int64
+
+	// identifiers already used as a label value, bounded by metrics.max_identifiers
+	identifiersMu sync.Mutex
+	identifiers   map[string]bool
+}
+
+// newAppMetrics : register the collectors, including the pool and cache of the storage in use
+func newAppMetrics(rh *RequestHandler) *appMetrics {
+	m := &appMetrics{
+		registry: prometheus.NewRegistry(),
+		alertsReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "alerts_received_total",
+			Help:      "Alerts received from Event Alerts by destination type, identifier and outcome.",
+		}, []string{"type", "identifier", "status"}),
+		parseErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "alert_parse_errors_total",
+			Help:      "Alerts rejected because the payload could not be parsed.",
+		}, []string{"type"}),
+		deliveries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "deliveries_total",
+			Help:      "Delivery attempts by destination type and HTTP status class, error when the destination could not be reached.",
+		}, []string{"type", "status_class"}),
+		handlingDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "alert_handling_seconds",
+			Help:      "Time taken to handle an inbound alert, delivery included.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"type"}),
+		deliveryDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "delivery_seconds",
+			Help:      "Time taken by Teams or PagerDuty to accept a delivery.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"type"}),
+		inFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "deliveries_in_flight",
+			Help:      "Deliveries waiting on Teams or PagerDuty.",
+		}),
+		identifiers: make(map[string]bool),
+	}
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.alertsReceived, m.parseErrors, m.deliveries, m.handlingDuration, m.deliveryDuration, m.inFlight,
+	)
+	if db, ok := rh.sqlDatabase(); ok {
+		m.registry.MustRegister(collectors.NewDBStatsCollector(db.conn, db.dialect.name))
+	}
+	if db, ok := rh.dbConn.(*resilientDB); ok {
+		m.registry.MustRegister(
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Namespace: metricsNamespace,
+				Name:      "cache_hits_total",
+				Help:      "Mapping lookups answered from the cache.",
+			}, func() float64 { return float64(db.hits.Load()) }),
+			prometheus.NewCounterFunc(prometheus.CounterOpts{
+				Namespace: metricsNamespace,
+				Name:      "cache_misses_total",
+				Help:      "Mapping lookups that went to the database.",
+			}, func() float64 { return float64(db.misses.Load()) }),
+		)
+	}
+	return m
+}
+
+//MetricsHandler : serves /metrics in the Prometheus exposition format
+func (rh *RequestHandler) MetricsHandler() http.Handler {
+	return promhttp.HandlerFor(rh.metrics.registry, promhttp.HandlerOpts{})
+}
+
+// identifierLabel : the identifier itself for the first max mapped identifiers, so an
+// identifier per alert source cannot blow up the number of series
+func (m *appMetrics) identifierLabel(identifier string, mapped bool, max int) string {
+	if !mapped {
+		return unmappedIdentifier
+	}
+	if max <= 0 {
+		max = defaultMaxIdentifierLabels
+	}
+	m.identifiersMu.Lock()
+	defer m.identifiersMu.Unlock()
+	if !m.identifiers[identifier] {
+		if len(m.identifiers) >= max {
+			return otherIdentifier
+		}
+		m.identifiers[identifier] = true
+	}
+	return identifier
+}
+
+// alertReceived : count, log and keep one inbound alert and its outcome. detail explains a failure.
+func (rh *RequestHandler) alertReceived(ctx context.Context, routeType string, identifier string, status string, detail string) {
+	level := slog.LevelInfo
+	if status != alertDelivered {
+		level = slog.LevelWarn
+	}
+	slog.Log(ctx, level, "alert handled", "outcome", status)
+	traceAlert(ctx, routeType, identifier, status)
+	label := rh.metrics.identifierLabel(identifier, status != alertUnmapped, rh.applConfig().Metrics.MaxIdentifiers)
+	rh.metrics.alertsReceived.WithLabelValues(routeType, label, status).Inc()
+	if status == alertInvalid {
+		rh.metrics.parseErrors.WithLabelValues(routeType).Inc()
+	}
+	rh.recordEvent(ctx, routeType, identifier, status, detail)
+}
+
+// observeHandling : record the time spent on an inbound alert, deferred by the alert handlers
+func (m *appMetrics) observeHandling(routeType string, start time.Time) {
+	m.handlingDuration.WithLabelValues(routeType).Observe(time.Since(start).Seconds())
+}
+
+// deliver : run one delivery attempt, tracking it while in flight and recording its outcome
+func (m *appMetrics) deliver(routeType string, attempt func() (helpers.DeliveryResult, error)) (helpers.DeliveryResult, error) {
+	m.inFlight.Inc()
+	m.pending.Add(1)
+	defer func() {
+		m.inFlight.Dec()
+		m.pending.Add(-1)
+	}()
+	start := time.Now()
+	result, err := attempt()
+	m.deliveryDuration.WithLabelValues(routeType).Observe(time.Since(start).Seconds())
+	m.deliveries.WithLabelValues(routeType, statusClass(result.Status)).Inc()
+	return result, err
+}
+
+// statusClass : 2xx, 4xx, 5xx..., error when no response was received
+func statusClass(status int) string {
+	if status == 0 {
+		return "error"
+	}
+	return fmt.Sprintf("%dxx", status/100)
+}
@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// recentEventsSize : alerts kept per instance for the events endpoint and the admin UI
+	recentEventsSize = 200
+	// defaultEventsLimit : events answered when no limit is given
+	defaultEventsLimit = 50
+)
+
+// recentEvent : one inbound alert and what became of it
+type recentEvent struct {
+	Time       time.Time `json:"time"`
+	RouteType  string    `json:"routeType"`
+	Identifier string    `json:"identifier"`
+	Outcome    string    `json:"outcome"`
+	Error      string    `json:"error,omitempty"`
+	RequestID  string    `json:"requestId,omitempty"`
+}
+
+// eventRing : the last recentEventsSize events, overwritten oldest first
+type eventRing struct {
+	mu     sync.Mutex
+	events []recentEvent
+	next   int
+}
+
+func (ring *eventRing) record(event recentEvent) {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	if len(ring.events) < recentEventsSize {
+		ring.events = append(ring.events, event)
+		return
+	}
+	ring.events[ring.next] = event
+	ring.next = (ring.next + 1) % recentEventsSize
+}
+
+// list : up to limit events, newest first, restricted to outcome unless it is empty
+func (ring *eventRing) list(outcome string, limit int) []recentEvent {
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	events := []recentEvent{}
+	for i := 0; i < len(ring.events) && len(events) < limit; i++ {
+		// Walk back from the most recent one
+		event := ring.events[(ring.next-1-i+2*len(ring.events))%len(ring.events)]
+		if outcome == "" || event.Outcome == outcome {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// recordEvent : keep the alert for the events endpoint. detail must not carry secrets.
+func (rh *RequestHandler) recordEvent(ctx context.Context, routeType string, identifier string, status string, detail string) {
+	event := recentEvent{
+		Time:       time.Now().UTC(),
+		RouteType:  routeType,
+		Identifier: identifier,
+		Outcome:    status,
+		Error:      detail,
+	}
+	fields, _ := ctx.Value(logFieldsKey{}).([]slog.Attr)
+	for _, field := range fields {
+		if field.Key == "request_id" {
+			event.RequestID = field.Value.String()
+		}
+	}
+	rh.events.record(event)
+}
+
+// deliveryError : err of a delivery to route, with the webhook URL or routing key masked
+func deliveryError(route *routes, err error) string {
+	if route.PostURL == "" {
+		return err.Error()
+	}
+	return strings.Replace(err.Error(), route.PostURL, maskSecret(route.PostURL), -1)
+}
+
+//RecentEvents : GET request listing the last alerts handled by this instance, newest first.
+//outcome restricts them to delivered, failed, unmapped, invalid, rejected or spooled.
+func (rh *RequestHandler) RecentEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	outcome := query.Get("outcome")
+	switch outcome {
+	case "", alertDelivered, alertFailed, alertUnmapped, alertInvalid, alertRejected, alertSpooled:
+	default:
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid outcome parameter")
+		return
+	}
+	limit := defaultEventsLimit
+	if value := query.Get("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid limit parameter")
+			return
+		}
+		limit = parsed
+	}
+	writeData(w, r, http.StatusOK, rh.events.list(outcome, limit))
+}
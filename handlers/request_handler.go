@@ -1,16 +1,39 @@
 package handlers
 
 import (
+	"context"
 	"fmt"
-	"log"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
 
-	"gopkg.in/yaml.v2"
+	"github.com/tushardag/pcf-eventalert-integration/helpers"
 )
 
 //RequestHandler : Application-wide configuration to allow passing already established DB interface
 type RequestHandler struct {
-	dbConn     *mysqlDB
-	applConfig *applicationConfig
+	dbConn mappingDatabase
+	// storageMode is the resolved storage_mode, including the detected database engine
+	storageMode string
+	metrics     *appMetrics
+	// destinations caches the reachability of the Teams and PagerDuty hosts
+	destinations destinationProbe
+	// deliveries tracks the alerts on their way to Teams or PagerDuty for the shutdown
+	deliveries deliveryTracker
+	// testAlerts rate limits the test alerts of each mapping
+	testAlerts testAlertLimiter
+	// events keeps the last alerts handled, for the events endpoint and the admin UI
+	events eventRing
+	// tracingShutdown flushes the spans of the configured exporter
+	tracingShutdown func(context.Context) error
+	// config is swapped as a whole on reload, handlers read it through applConfig()
+	config atomic.Pointer[configState]
+
+	// configPath, reloadMu and lastReloadError belong to the config reload
+	configPath      string
+	reloadMu        sync.Mutex
+	lastReloadError atomic.Pointer[string]
 }
 
 // Routes holds metadata about a route mapping records.
@@ -19,41 +42,103 @@ type routes struct {
 	RouteType   string
 	PostURL     string
 	Description string
+	// Version is bumped on every update and served as the mapping's ETag.
+	Version   int64      `json:",omitempty"`
+	UpdatedAt *time.Time `json:",omitempty"`
 }
 
 //RequestHandlerInit : Initializing the DB session
-func RequestHandlerInit(config MySQLConfig, yamlFile []byte) (*RequestHandler, error) {
+func RequestHandlerInit(config DBConfig, yamlFile []byte) (*RequestHandler, error) {
 	var rh RequestHandler
-	var err error
-	err = yaml.Unmarshal(yamlFile, &rh.applConfig)
+	applConfig, err := parseApplicationConfig(yamlFile)
 	if err != nil {
 		return nil, err
 	}
-	// fmt.Println("Teams name: " + rh.applConfig.Notifications[0].Name)
-	if rh.applConfig.EnableMysql {
-		fmt.Println("Establishing MySQL DB Connection")
-		rh.dbConn, err = newDBConnection(config)
+	rh.config.Store(newConfigState(applConfig, yamlFile))
+	if err := applConfig.Logging.apply(); err != nil {
+		return nil, err
+	}
+	if rh.tracingShutdown, err = setupTracing(applConfig.Tracing); err != nil {
+		return nil, err
+	}
+	if config, err = applConfig.boundDatabase(config); err != nil {
+		return nil, err
+	}
+	mode := applConfig.storageMode(config.Driver)
+	switch mode {
+	case storageMySQL, storagePostgres:
+		if config.Driver != "" && config.Driver != mode {
+			return nil, fmt.Errorf("storage_mode is %s but the bound database service is %s", mode, config.Driver)
+		}
+		config.Driver = mode
+		config = applConfig.Database.apply(config)
+		slog.Info("Establishing DB connection", "driver", mode)
+		db, err := connectWithRetry(config, applConfig.Database.StartupTimeout)
+		if err != nil {
+			slog.Error("Unable to get DB connection", "error", err)
+			return nil, err
+		}
+		// Lookups are cached, and fall back to the cache when the remote database goes away
+		if rh.dbConn, err = newResilientDB(db, applConfig.Database.CacheTTL, applConfig.Database.CachePollInterval); err != nil {
+			db.close()
+			return nil, err
+		}
+		slog.Info("Successfully established DB connection")
+	case storageSQLite:
+		slog.Info("Opening SQLite DB", "path", applConfig.SQLitePath)
+		rh.dbConn, err = newDBConnection(DBConfig{Driver: storageSQLite, Database: applConfig.SQLitePath})
+		if err != nil {
+			slog.Error("Unable to open SQLite DB", "error", err)
+			return nil, err
+		}
+	case storageMemory, storageConfig:
+		slog.Info("Application is being configured to run with NO DB instance, mappings are kept in memory")
+		rh.dbConn, err = newMemoryDB(applConfig.Notifications, applConfig.MappingsFile)
 		if err != nil {
-			log.Println("Unable to get DB connection")
 			return nil, err
 		}
-		fmt.Println("Successfully established DB connection")
-		//fmt.Println(dbConn)
-	} else {
-		fmt.Println("Application is being configured to run with NO MySQL DB instance.")
+	}
+	rh.storageMode = mode
+	rh.metrics = newAppMetrics(&rh)
+	go rh.watchDestinations()
+	if spool := applConfig.Shutdown.SpoolFile; spool != "" {
+		go rh.replaySpool(spool)
 	}
 	return &rh, nil
 }
 
+// boundDatabase : in PCF, the connection settings of the bound database service replace the local ones
+func (applConfig *applicationConfig) boundDatabase(local DBConfig) (DBConfig, error) {
+	switch applConfig.storageMode("") {
+	case storageMemory, storageConfig, storageSQLite:
+		return local, nil
+	}
+	services, err := helpers.ParseVCAPServices()
+	if err != nil || len(services) == 0 {
+		return local, err
+	}
+	return resolveDatabaseBinding(services, applConfig.DatabaseService)
+}
+
 //CloseDB : Free up the DB resource
 func (rh *RequestHandler) CloseDB() {
-	if rh.applConfig.EnableMysql {
-		fmt.Println("Closing the DB connection.")
-		rh.dbConn.close()
-	}
+	slog.Info("Closing the DB connection")
+	rh.dbConn.close()
 }
 
-//DBinUse : Return if the MySQL DB is in use
+//DBinUse : Return if a MySQL, PostgreSQL or SQLite DB is in use
 func (rh *RequestHandler) DBinUse() bool {
-	return rh.applConfig.EnableMysql
+	_, ok := rh.sqlDatabase()
+	return ok
+}
+
+// sqlDatabase : the SQL database behind dbConn, if any
+func (rh *RequestHandler) sqlDatabase() (*sqlDB, bool) {
+	switch db := rh.dbConn.(type) {
+	case *sqlDB:
+		return db, true
+	case *resilientDB:
+		return db.db, true
+	}
+	return nil, false
 }
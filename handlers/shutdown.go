@@ -0,0 +1,326 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tushardag/pcf-eventalert-integration/helpers"
+)
+
+const (
+	// defaultDrainTimeout : CF sends SIGKILL 10 seconds after SIGTERM, leave room to close up
+	defaultDrainTimeout = 8 * time.Second
+	// flushTimeout : bounds the trace export once the drain is over
+	flushTimeout = 2 * time.Second
+	// abortTimeout : how long the cancelled deliveries get to return before they are spooled
+	abortTimeout = 200 * time.Millisecond
+)
+
+// errDeliveryAborted : the shutdown cancelled the delivery before it got through
+var errDeliveryAborted = errors.New("delivery cancelled by the shutdown")
+
+// pendingDelivery : an alert on its way to Teams or PagerDuty. Spooled without the webhook URL
+// or routing key, the mapping is looked up again when it is replayed.
+type pendingDelivery struct {
+	RouteType  string              `json:"routeType"`
+	Identifier string              `json:"identifier"`
+	Alert      *helpers.EventAlert `json:"alert"`
+	Received   time.Time           `json:"received"`
+
+	// cancel aborts the delivery on shutdown, aborted tells it was
+	cancel  context.CancelFunc
+	aborted bool
+}
+
+// deliveryTracker : deliveries in flight, so that a shutdown knows what it is waiting for
+type deliveryTracker struct {
+	mu      sync.Mutex
+	pending map[int64]*pendingDelivery
+	nextID  int64
+	// failed : aborted deliveries that did not get through, spooled with the pending ones
+	failed []*pendingDelivery
+	// settled is closed once the shutdown spooled or dropped them, spooled tells which
+	settled chan struct{}
+	spooled bool
+
+	// draining is set once shutdown started, drained counts the deliveries finished since
+	draining atomic.Bool
+	drained  atomic.Int64
+}
+
+// track : register a delivery, the returned function is called with its outcome once it is over.
+// It returns true when the shutdown aborted the delivery and holds it for the spool.
+func (dt *deliveryTracker) track(delivery *pendingDelivery) func(error) bool {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	if dt.pending == nil {
+		dt.pending = make(map[int64]*pendingDelivery)
+	}
+	dt.nextID++
+	id := dt.nextID
+	dt.pending[id] = delivery
+	return func(err error) bool {
+		dt.mu.Lock()
+		defer dt.mu.Unlock()
+		delete(dt.pending, id)
+		if delivery.aborted && err != nil {
+			dt.failed = append(dt.failed, delivery)
+			return true
+		}
+		if dt.draining.Load() {
+			dt.drained.Add(1)
+		}
+		return false
+	}
+}
+
+// abort : cancel the deliveries in flight and wait up to timeout for them to return. Returns
+// those that did not get through, an aborted delivery that completed anyway is not among them.
+func (dt *deliveryTracker) abort(timeout time.Duration) []*pendingDelivery {
+	dt.mu.Lock()
+	dt.settled = make(chan struct{})
+	for _, delivery := range dt.pending {
+		delivery.aborted = true
+		if delivery.cancel != nil {
+			delivery.cancel()
+		}
+	}
+	dt.mu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	for len(dt.snapshot()) > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	dt.mu.Lock()
+	failed := dt.failed
+	dt.failed = nil
+	dt.mu.Unlock()
+	return append(failed, dt.snapshot()...)
+}
+
+// settle : record whether the aborted deliveries made it to the spool, releasing the requests
+// waiting in heldForReplay
+func (dt *deliveryTracker) settle(spooled bool) {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	dt.spooled = spooled
+	close(dt.settled)
+}
+
+// heldForReplay : wait for the shutdown to settle an aborted delivery, true when it was spooled
+func (dt *deliveryTracker) heldForReplay() bool {
+	dt.mu.Lock()
+	settled := dt.settled
+	dt.mu.Unlock()
+	select {
+	case <-settled:
+	case <-time.After(flushTimeout):
+		return false
+	}
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	return dt.spooled
+}
+
+// snapshot : deliveries still in flight, oldest first
+func (dt *deliveryTracker) snapshot() []*pendingDelivery {
+	dt.mu.Lock()
+	defer dt.mu.Unlock()
+	ids := make([]int64, 0, len(dt.pending))
+	for id := range dt.pending {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	deliveries := make([]*pendingDelivery, 0, len(ids))
+	for _, id := range ids {
+		deliveries = append(deliveries, dt.pending[id])
+	}
+	return deliveries
+}
+
+// send : compile the alert for the destination of route and deliver it, tracked until it is over
+func (rh *RequestHandler) send(ctx context.Context, route *routes, alert *helpers.EventAlert) (helpers.DeliveryResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := rh.deliveries.track(&pendingDelivery{
+		RouteType:  route.RouteType,
+		Identifier: route.Identifier,
+		Alert:      alert,
+		Received:   time.Now().UTC(),
+		cancel:     cancel,
+	})
+	ctx = rh.deliveryContext(ctx, route.RouteType)
+	result, err := rh.metrics.deliver(route.RouteType, func() (helpers.DeliveryResult, error) {
+		switch route.RouteType {
+		case teamsType:
+			return helpers.CompileTeamsMessage(alert).PostMessage(ctx, route.PostURL)
+		case pagerdutyType:
+			return helpers.CompilePagerDutyMessage(alert, route.PostURL).CreateIncident(ctx)
+		}
+		return helpers.DeliveryResult{}, fmt.Errorf("unsupported route type %s", route.RouteType)
+	})
+	if done(err) {
+		return result, fmt.Errorf("%w: %v", errDeliveryAborted, err)
+	}
+	return result, err
+}
+
+// writeAborted : answer an alert whose delivery the shutdown cancelled. Spooled, it is replayed
+// on the next start and a retry would notify twice, so it is accepted with 202. Otherwise 503
+// asks Event Alerts to retry. Returns true when err is such a delivery.
+func (rh *RequestHandler) writeAborted(ctx context.Context, w http.ResponseWriter, r *http.Request, route *routes, err error) bool {
+	if !errors.Is(err, errDeliveryAborted) {
+		return false
+	}
+	if !rh.deliveries.heldForReplay() {
+		rh.alertReceived(ctx, route.RouteType, route.Identifier, alertRejected, deliveryError(route, err))
+		w.Header().Set("Retry-After", strconv.Itoa(int(defaultDrainTimeout.Seconds())))
+		writeError(w, r, http.StatusServiceUnavailable, codeShuttingDown, "Shutting down, the alert was not delivered. Retry later")
+		return true
+	}
+	slog.WarnContext(ctx, "Delivery cancelled by the shutdown, spooled for the next start")
+	rh.alertReceived(ctx, route.RouteType, route.Identifier, alertSpooled, "")
+	if !isAPIv1(r) {
+		w.WriteHeader(http.StatusAccepted)
+		return true
+	}
+	writeData(w, r, http.StatusAccepted, alertDelivery{
+		RouteType:  route.RouteType,
+		Identifier: route.Identifier,
+		Outcome:    alertSpooled,
+	})
+	return true
+}
+
+// rejectWhileDraining : answer 503 to alerts arriving once shutdown started. Returns true when rejected.
+func (rh *RequestHandler) rejectWhileDraining(w http.ResponseWriter, r *http.Request) bool {
+	if !rh.deliveries.draining.Load() {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(defaultDrainTimeout.Seconds())))
+	writeError(w, r, http.StatusServiceUnavailable, codeShuttingDown, "Shutting down, not accepting alerts. Retry later")
+	return true
+}
+
+//Shutdown : stop accepting requests, wait up to shutdown.drain_timeout for the requests and
+//deliveries in flight, cancel and spool or log those left over, then flush the traces and close the DB.
+func (rh *RequestHandler) Shutdown(server *http.Server) {
+	start := time.Now()
+	settings := rh.applConfig().Shutdown
+	timeout := settings.DrainTimeout
+	if timeout <= 0 {
+		timeout = defaultDrainTimeout
+	}
+	rh.deliveries.draining.Store(true)
+	slog.Info("Draining before shutdown", "in_flight", len(rh.deliveries.snapshot()), "timeout", timeout.String())
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("Drain timeout reached with requests still in flight", "error", err)
+	}
+
+	// Cancelled first, so that a spooled delivery cannot also go through and be sent twice
+	left := rh.deliveries.abort(abortTimeout)
+	persisted := 0
+	if len(left) > 0 && settings.SpoolFile != "" {
+		if err := spoolDeliveries(settings.SpoolFile, left); err != nil {
+			slog.Error("Unable to spool the deliveries in flight", "path", settings.SpoolFile, "error", err)
+		} else {
+			persisted = len(left)
+		}
+	}
+	if persisted == 0 {
+		for _, delivery := range left {
+			slog.Error("Dropping delivery still in flight", "identifier", delivery.Identifier, "route_type", delivery.RouteType,
+				"topic", delivery.Alert.Topic, "status", delivery.Alert.Metadata.Status, "received", delivery.Received)
+		}
+	}
+	// The requests of the aborted deliveries answer now, 202 when spooled and 503 otherwise
+	rh.deliveries.settle(persisted > 0)
+	answerCtx, cancelAnswer := context.WithTimeout(context.Background(), abortTimeout)
+	defer cancelAnswer()
+	server.Shutdown(answerCtx)
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), flushTimeout)
+	defer cancelFlush()
+	if err := rh.FlushTraces(flushCtx); err != nil {
+		slog.Warn("Unable to flush the remaining spans", "error", err)
+	}
+	rh.CloseDB()
+	slog.Info("Shutdown complete", "drained", rh.deliveries.drained.Load(), "persisted", persisted,
+		"dropped", len(left)-persisted, "duration", time.Since(start).Round(time.Millisecond).String())
+}
+
+// spoolDeliveries : append deliveries to path, one JSON object per line
+func spoolDeliveries(path string, deliveries []*pendingDelivery) error {
+	spool, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(spool)
+	for _, delivery := range deliveries {
+		if err := enc.Encode(delivery); err != nil {
+			spool.Close()
+			return err
+		}
+	}
+	return spool.Close()
+}
+
+// replaySpool : deliver what the previous instance spooled on shutdown. The spool is removed
+// first, a delivery failing again is logged and not spooled a second time.
+func (rh *RequestHandler) replaySpool(path string) {
+	spool, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		slog.Error("Unable to read the delivery spool", "path", path, "error", err)
+		return
+	}
+	var deliveries []*pendingDelivery
+	scanner := bufio.NewScanner(spool)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		delivery := new(pendingDelivery)
+		if err := json.Unmarshal(scanner.Bytes(), delivery); err != nil || delivery.Alert == nil {
+			slog.Warn("Skipping an unreadable line of the delivery spool", "path", path, "error", err)
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	spool.Close()
+	if err := os.Remove(path); err != nil {
+		slog.Error("Unable to remove the delivery spool, not replaying it", "path", path, "error", err)
+		return
+	}
+
+	replayed := 0
+	for _, delivery := range deliveries {
+		ctx := withLogFields(context.Background(), slog.String("identifier", delivery.Identifier),
+			slog.String("route_type", delivery.RouteType))
+		route, err := rh.dbConn.getRoute(ctx, delivery.Identifier, delivery.RouteType)
+		if err != nil {
+			slog.ErrorContext(ctx, "Unable to replay a spooled delivery, the mapping is gone", "error", err)
+			continue
+		}
+		if _, err := rh.send(ctx, route, delivery.Alert); err != nil {
+			slog.ErrorContext(ctx, "Unable to replay a spooled delivery", "received", delivery.Received, "error", deliveryError(route, err))
+			continue
+		}
+		replayed++
+	}
+	slog.Info("Replayed the delivery spool", "path", path, "replayed", replayed, "failed", len(deliveries)-replayed)
+}
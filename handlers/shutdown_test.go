@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// TestShutdownAbortsDelivery : a delivery still hanging when the drain times out is cancelled, and
+// its request answered 202 when spooled or 503 when not, never both spooled and failed
+func TestShutdownAbortsDelivery(t *testing.T) {
+	tests := []struct {
+		name  string
+		spool bool
+		want  int
+	}{
+		{name: "spooled", spool: true, want: http.StatusAccepted},
+		{name: "dropped", want: http.StatusServiceUnavailable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The first delivery hangs until the test is over, the replay goes through
+			release := make(chan struct{})
+			arrived := make(chan struct{}, 2)
+			var calls atomic.Int32
+			webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				arrived <- struct{}{}
+				if calls.Add(1) == 1 {
+					<-release
+				}
+				w.Write([]byte("1"))
+			}))
+			defer webhook.Close()
+			defer close(release)
+
+			db, err := newMemoryDB(nil, "")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := db.addRoute(&routes{Identifier: "app1", RouteType: teamsType, PostURL: webhook.URL + "/webhook"}); err != nil {
+				t.Fatal(err)
+			}
+			settings := shutdownSettings{DrainTimeout: 100 * time.Millisecond}
+			if tt.spool {
+				settings.SpoolFile = filepath.Join(t.TempDir(), "spool.jsonl")
+			}
+			rh := &RequestHandler{dbConn: db}
+			rh.config.Store(newConfigState(&applicationConfig{Shutdown: settings}, nil))
+			rh.metrics = newAppMetrics(rh)
+			router := mux.NewRouter()
+			router.HandleFunc("/teams/{identifier}", rh.MSTeamsAlert).Methods("POST")
+			app := httptest.NewServer(router)
+			defer app.Close()
+
+			answered := make(chan int, 1)
+			go func() {
+				res, err := http.Post(app.URL+"/teams/app1", "application/json", strings.NewReader(tracedAlert))
+				if err != nil {
+					t.Error(err)
+					answered <- 0
+					return
+				}
+				res.Body.Close()
+				answered <- res.StatusCode
+			}()
+			<-arrived
+			rh.Shutdown(app.Config)
+			if status := <-answered; status != tt.want {
+				t.Fatalf("alert answered %d, want %d", status, tt.want)
+			}
+			if !tt.spool {
+				return
+			}
+
+			spool, err := os.ReadFile(settings.SpoolFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if lines := bytes.Count(spool, []byte("\n")); lines != 1 {
+				t.Fatalf("%d deliveries spooled, want 1", lines)
+			}
+			// The next start delivers it once more, and only then
+			rh.replaySpool(settings.SpoolFile)
+			select {
+			case <-arrived:
+			default:
+				t.Fatal("spooled delivery was not replayed")
+			}
+			if _, err := os.Stat(settings.SpoolFile); !os.IsNotExist(err) {
+				t.Errorf("spool left behind after the replay: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/tushardag/pcf-eventalert-integration/helpers"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracingNone   = "none"
+	tracingStdout = "stdout"
+	tracingOTLP   = "otlp"
+
+	// serviceName : reported on every span, OTEL_SERVICE_NAME overrides it
+	serviceName = "pcf-eventalert-integration"
+)
+
+// tracer : spans of the handlers package, follows the provider installed by setupTracing
+var tracer = otel.Tracer("github.com/tushardag/pcf-eventalert-integration/handlers")
+
+// setupTracing : install the exporter named in the tracing block as the global tracer provider.
+// Incoming W3C trace context is honoured in any case, so traces started upstream carry on here.
+// The returned function flushes the spans still buffered.
+func setupTracing(settings tracingSettings) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(settings.Exporter) {
+	case "", tracingNone:
+		return func(context.Context) error { return nil }, nil
+	case tracingStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case tracingOTLP:
+		var options []otlptracehttp.Option
+		if settings.Endpoint != "" {
+			// Without an endpoint, OTEL_EXPORTER_OTLP_ENDPOINT or localhost:4318 is used
+			options = append(options, otlptracehttp.WithEndpointURL(settings.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), options...)
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %q, expected %s, %s or %s", settings.Exporter, tracingNone, tracingStdout, tracingOTLP)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("tracing: could not create the %s exporter: %v", settings.Exporter, err)
+	}
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(serviceName)))
+	if err != nil {
+		res = resource.Default()
+	}
+	provider := sdktrace.NewTracerProvider(
+		// No sampler given, OTEL_TRACES_SAMPLER applies and every trace is recorded by default
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+//TraceRequests : middleware starting a server span per request, named after the matched
+//route template so that all alerts for a type end up under one span name.
+func TraceRequests(next http.Handler) http.Handler {
+	return otelhttp.NewHandler(next, "http.request",
+		otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+			if route := mux.CurrentRoute(r); route != nil {
+				if template, err := route.GetPathTemplate(); err == nil {
+					return r.Method + " " + template
+				}
+			}
+			return r.Method
+		}))
+}
+
+//FlushTraces : export the spans still buffered, called on shutdown
+func (rh *RequestHandler) FlushTraces(ctx context.Context) error {
+	if rh.tracingShutdown == nil {
+		return nil
+	}
+	return rh.tracingShutdown(ctx)
+}
+
+// traceAlert : record the outcome of an alert on the span of its request
+func traceAlert(ctx context.Context, routeType string, identifier string, status string) {
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(
+		attribute.String("alert.route_type", routeType),
+		attribute.String("alert.identifier", identifier),
+		attribute.String("alert.outcome", status),
+	)
+	if status == alertFailed {
+		span.SetStatus(codes.Error, "delivery failed")
+	}
+}
+
+// deliveryContext : ctx, sending the trace context along to the destination of routeType when
+// tracing.propagate lists it
+func (rh *RequestHandler) deliveryContext(ctx context.Context, routeType string) context.Context {
+	for _, destination := range rh.applConfig().Tracing.Propagate {
+		if destination == routeType {
+			return helpers.WithTracePropagation(ctx)
+		}
+	}
+	return ctx
+}
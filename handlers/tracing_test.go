@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	upstreamTraceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	upstreamSpanID  = "00f067aa0ba902b7"
+)
+
+const tracedAlert = `{"topic": "eventalert.test", "metadata": {"status": "critical", "eventDescription": "Disk full", "foundation": "dev"}}`
+
+func TestAlertSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	received := make(chan string, 1)
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- r.Header.Get("traceparent")
+		w.Write([]byte("1"))
+	}))
+	defer webhook.Close()
+
+	db, err := newDBConnection(DBConfig{Driver: storageSQLite, Database: filepath.Join(t.TempDir(), "mappings.db")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached, err := newResilientDB(db, time.Hour, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cached.close()
+	// Added behind the cache, so that the lookup goes to the database
+	if err := db.addRoute(&routes{Identifier: "app1", RouteType: teamsType, PostURL: webhook.URL + "/webhook"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rh := &RequestHandler{dbConn: cached}
+	rh.config.Store(newConfigState(&applicationConfig{}, nil))
+	rh.metrics = newAppMetrics(rh)
+	router := mux.NewRouter()
+	router.Use(TraceRequests)
+	router.HandleFunc(APIPrefix+"/alerts/teams/{identifier}", rh.MSTeamsAlert).Methods("POST")
+
+	post := func() {
+		req := httptest.NewRequest("POST", APIPrefix+"/alerts/teams/app1", strings.NewReader(tracedAlert))
+		req.Header.Set("traceparent", "00-"+upstreamTraceID+"-"+upstreamSpanID+"-01")
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("alert answered %d: %s", rec.Code, rec.Body.String())
+		}
+	}
+
+	post()
+	if traceparent := <-received; traceparent != "" {
+		t.Errorf("traceparent %q sent to Teams without tracing.propagate", traceparent)
+	}
+
+	spans := make(map[string]sdktrace.ReadOnlySpan)
+	for _, span := range recorder.Ended() {
+		spans[span.Name()] = span
+	}
+	inbound, lookup, query, delivery := spans["POST /api/v1/alerts/teams/{identifier}"], spans["mapping.lookup"], spans["db.getRoute"], spans["teams.post"]
+	for name, span := range map[string]sdktrace.ReadOnlySpan{"inbound": inbound, "lookup": lookup, "database": query, "delivery": delivery} {
+		if span == nil {
+			t.Fatalf("no %s span among %d recorded", name, len(spans))
+		}
+		if got := span.SpanContext().TraceID().String(); got != upstreamTraceID {
+			t.Errorf("%s span is in trace %s, want the upstream %s", name, got, upstreamTraceID)
+		}
+	}
+	if inbound.SpanKind() != trace.SpanKindServer || !inbound.Parent().IsRemote() || inbound.Parent().SpanID().String() != upstreamSpanID {
+		t.Errorf("inbound span is a %v child of %v, want a server span under the upstream one", inbound.SpanKind(), inbound.Parent().SpanID())
+	}
+	for _, link := range []struct {
+		name          string
+		child, parent sdktrace.ReadOnlySpan
+	}{
+		{"lookup", lookup, inbound},
+		{"database", query, lookup},
+		{"delivery", delivery, inbound},
+	} {
+		if link.child.Parent().SpanID() != link.parent.SpanContext().SpanID() {
+			t.Errorf("%s span is a child of %v, want %s", link.name, link.child.Parent().SpanID(), link.parent.Name())
+		}
+	}
+	if delivery.SpanKind() != trace.SpanKindClient {
+		t.Errorf("delivery span is a %v span, want a client span", delivery.SpanKind())
+	}
+
+	// Opted in, Teams gets the trace of the delivery span
+	rh.config.Store(newConfigState(&applicationConfig{Tracing: tracingSettings{Propagate: []string{teamsType}}}, nil))
+	post()
+	if traceparent := <-received; !strings.HasPrefix(traceparent, "00-"+upstreamTraceID+"-") {
+		t.Errorf("traceparent %q sent to Teams, want one in trace %s", traceparent, upstreamTraceID)
+	}
+
+	// A webhook that cannot be reached keeps its path, the secret part, out of the span
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+	secretPath := "/webhookb2/5e3f0a7c-secret"
+	if err := db.addRoute(&routes{Identifier: "app2", RouteType: teamsType, PostURL: closed.URL + secretPath}); err != nil {
+		t.Fatal(err)
+	}
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest("POST", APIPrefix+"/alerts/teams/app2", strings.NewReader(tracedAlert)))
+	if rec.Code == http.StatusOK {
+		t.Fatal("alert to a closed port was delivered")
+	}
+	var failed sdktrace.ReadOnlySpan
+	for _, span := range recorder.Ended() {
+		if span.Name() == "teams.post" && span.Status().Code == codes.Error {
+			failed = span
+		}
+	}
+	if failed == nil {
+		t.Fatal("no failed delivery span")
+	}
+	texts := []string{failed.Status().Description}
+	for _, event := range failed.Events() {
+		for _, attr := range event.Attributes {
+			texts = append(texts, attr.Value.Emit())
+		}
+	}
+	for _, text := range texts {
+		if strings.Contains(text, secretPath) {
+			t.Errorf("failed delivery span holds the webhook path: %s", text)
+		}
+	}
+	if !strings.Contains(failed.Status().Description, "connection refused") {
+		t.Errorf("failed delivery span status %q, want the transport error", failed.Status().Description)
+	}
+}
@@ -0,0 +1,273 @@
+// Admin UI of pcf-eventalert-integration. Everything goes through /api/v1 with the admin
+// token kept in sessionStorage, so the page itself holds no data and needs nothing from outside.
+(function () {
+  'use strict';
+
+  var tokenKey = 'eventalert-admin-token';
+  var eventsInterval = 15000;
+  // The mapping being edited, null while creating one
+  var editing = null;
+
+  function $(id) {
+    return document.getElementById(id);
+  }
+
+  // api : call the v1 API, resolving to the data of the envelope and rejecting with its error
+  function api(method, path, body, headers) {
+    var init = { method: method, headers: { Accept: 'application/json' } };
+    Object.keys(headers || {}).forEach(function (name) {
+      init.headers[name] = headers[name];
+    });
+    var token = sessionStorage.getItem(tokenKey);
+    if (token) {
+      init.headers.Authorization = 'Bearer ' + token;
+    }
+    if (body !== undefined) {
+      init.headers['Content-Type'] = 'application/json';
+      init.body = JSON.stringify(body);
+    }
+    return fetch(new URL('../api/v1' + path, window.location.href), init).then(function (resp) {
+      if (resp.status === 204) {
+        return null;
+      }
+      return resp.json().catch(function () {
+        return null;
+      }).then(function (doc) {
+        if (!resp.ok) {
+          var failure = (doc && doc.error) || { code: 'http_' + resp.status, message: resp.statusText };
+          var err = new Error(failure.message);
+          err.status = resp.status;
+          err.code = failure.code;
+          err.details = failure.details;
+          throw err;
+        }
+        return doc ? doc.data : null;
+      });
+    });
+  }
+
+  function show(text, isError) {
+    var message = $('message');
+    message.textContent = text;
+    message.className = isError ? 'error' : '';
+    message.hidden = false;
+  }
+
+  function showError(err) {
+    if (err.status === 401) {
+      show('An admin token is needed. Enter one of the admin_tokens above.', true);
+      return;
+    }
+    show(err.message + (err.code ? ' (' + err.code + ')' : ''), true);
+  }
+
+  function cell(row, text, className) {
+    var td = document.createElement('td');
+    td.textContent = text === undefined || text === null ? '' : String(text);
+    if (className) {
+      td.className = className;
+    }
+    row.appendChild(td);
+    return td;
+  }
+
+  function button(parent, label, onClick) {
+    var b = document.createElement('button');
+    b.type = 'button';
+    b.textContent = label;
+    b.addEventListener('click', onClick);
+    parent.appendChild(b);
+    return b;
+  }
+
+  // mask : keep the host of a webhook and the last characters of its secret part
+  function mask(secret) {
+    var prefix = '';
+    var match = /^(https?:\/\/[^/]+\/)(.*)$/.exec(secret || '');
+    if (match) {
+      prefix = match[1];
+      secret = match[2];
+    }
+    if (secret.length <= 4) {
+      return prefix + secret.replace(/./g, '*');
+    }
+    return prefix + '****' + secret.slice(-4);
+  }
+
+  function mappingPath(mapping) {
+    return '/mappings/' + encodeURIComponent(mapping.RouteType) + '/' + encodeURIComponent(mapping.Identifier);
+  }
+
+  function loadMappings() {
+    return api('GET', '/mappings').then(function (mappings) {
+      var tbody = $('mappings');
+      tbody.textContent = '';
+      mappings.sort(function (a, b) {
+        return a.Identifier.localeCompare(b.Identifier) || a.RouteType.localeCompare(b.RouteType);
+      });
+      mappings.forEach(function (mapping) {
+        var row = document.createElement('tr');
+        cell(row, mapping.Identifier);
+        cell(row, mapping.RouteType);
+        cell(row, mask(mapping.PostURL), 'endpoint');
+        cell(row, mapping.Description);
+        cell(row, mapping.Version);
+        var actions = cell(row, '', 'actions');
+        button(actions, 'Edit', function () {
+          openForm(mapping);
+        });
+        button(actions, 'Test', function () {
+          testMapping(mapping);
+        });
+        button(actions, 'Delete', function () {
+          deleteMapping(mapping);
+        });
+        tbody.appendChild(row);
+      });
+      if (mappings.length === 0) {
+        var empty = document.createElement('tr');
+        cell(empty, 'No mapping yet.').colSpan = 6;
+        tbody.appendChild(empty);
+      }
+    }).catch(showError);
+  }
+
+  function openForm(mapping) {
+    editing = mapping || null;
+    $('mapping-form-title').textContent = editing ? 'Edit ' + editing.RouteType + '/' + editing.Identifier : 'New mapping';
+    $('mapping-type').value = editing ? editing.RouteType : 'teams';
+    $('mapping-identifier').value = editing ? editing.Identifier : '';
+    $('mapping-url').value = editing ? editing.PostURL : '';
+    $('mapping-description').value = editing ? editing.Description : '';
+    // The type and identifier make up the path of a mapping, they cannot be edited
+    $('mapping-type').disabled = !!editing;
+    $('mapping-identifier').disabled = !!editing;
+    $('mapping-form').hidden = false;
+    $('mapping-url').focus();
+  }
+
+  function closeForm() {
+    editing = null;
+    $('mapping-form').hidden = true;
+  }
+
+  function saveMapping(event) {
+    event.preventDefault();
+    var mapping = {
+      RouteType: $('mapping-type').value,
+      Identifier: $('mapping-identifier').value.trim()
+    };
+    var headers = {};
+    if (editing) {
+      // Refuse to overwrite a change made since the list was loaded
+      headers['If-Match'] = '"' + editing.Version + '"';
+    }
+    api('PUT', mappingPath(mapping), {
+      URL: $('mapping-url').value.trim(),
+      description: $('mapping-description').value
+    }, headers).then(function (saved) {
+      show('Saved ' + saved.RouteType + '/' + saved.Identifier + ', version ' + saved.Version + '.');
+      closeForm();
+      loadMappings();
+    }).catch(function (err) {
+      if (err.code === 'version_conflict') {
+        show('Somebody else changed this mapping in the meantime. The list was reloaded, edit it again.', true);
+        closeForm();
+        loadMappings();
+        return;
+      }
+      showError(err);
+    });
+  }
+
+  function deleteMapping(mapping) {
+    if (!window.confirm('Delete the ' + mapping.RouteType + ' mapping of ' + mapping.Identifier + '? Alerts sent to it will fail.')) {
+      return;
+    }
+    api('DELETE', mappingPath(mapping)).then(function () {
+      show('Deleted ' + mapping.RouteType + '/' + mapping.Identifier + '.');
+      loadMappings();
+    }).catch(showError);
+  }
+
+  function testMapping(mapping) {
+    show('Sending a test alert through ' + mapping.RouteType + '/' + mapping.Identifier + '...');
+    api('POST', mappingPath(mapping) + '/test').then(function (result) {
+      show('Test alert delivered through ' + mapping.RouteType + '/' + mapping.Identifier + '.');
+      showTest(result);
+    }).catch(function (err) {
+      showError(err);
+      if (err.details) {
+        showTest(err.details);
+      }
+    }).then(loadEvents);
+  }
+
+  function showTest(result) {
+    $('test-output').textContent = JSON.stringify(result, null, 2);
+    $('test-result').hidden = false;
+  }
+
+  function loadEvents() {
+    var outcome = $('events-outcome').value;
+    var query = '?limit=100' + (outcome ? '&outcome=' + encodeURIComponent(outcome) : '');
+    return api('GET', '/events' + query).then(function (events) {
+      var tbody = $('events');
+      tbody.textContent = '';
+      events.forEach(function (event) {
+        var row = document.createElement('tr');
+        cell(row, new Date(event.time).toLocaleString());
+        cell(row, event.outcome, 'outcome-' + event.outcome);
+        cell(row, event.routeType);
+        cell(row, event.identifier);
+        cell(row, event.error, 'error');
+        cell(row, event.requestId);
+        tbody.appendChild(row);
+      });
+      if (events.length === 0) {
+        var empty = document.createElement('tr');
+        cell(empty, 'Nothing yet.').colSpan = 6;
+        tbody.appendChild(empty);
+      }
+    }).catch(function (err) {
+      // The mappings call reports missing tokens already
+      if (err.status !== 401) {
+        showError(err);
+      }
+    });
+  }
+
+  function useToken(event) {
+    event.preventDefault();
+    var token = $('token').value.trim();
+    if (token) {
+      sessionStorage.setItem(tokenKey, token);
+    } else {
+      sessionStorage.removeItem(tokenKey);
+    }
+    $('token').value = '';
+    $('message').hidden = true;
+    refresh();
+  }
+
+  function refresh() {
+    $('token').placeholder = sessionStorage.getItem(tokenKey) ? 'token set for this tab' : 'only needed when admin_tokens are set';
+    loadMappings();
+    loadEvents();
+  }
+
+  $('token-form').addEventListener('submit', useToken);
+  $('token-clear').addEventListener('click', function () {
+    sessionStorage.removeItem(tokenKey);
+    refresh();
+  });
+  $('mapping-new').addEventListener('click', function () {
+    openForm(null);
+  });
+  $('mapping-cancel').addEventListener('click', closeForm);
+  $('mapping-form').addEventListener('submit', saveMapping);
+  $('events-outcome').addEventListener('change', loadEvents);
+  $('events-refresh').addEventListener('click', loadEvents);
+  window.setInterval(loadEvents, eventsInterval);
+  refresh();
+}());
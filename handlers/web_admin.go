@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+)
+
+//SchemaStatus : report the schema version of event_router_mapping and any pending migrations
+func (rh *RequestHandler) SchemaStatus(w http.ResponseWriter, r *http.Request) {
+	db, ok := rh.sqlDatabase()
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeNotAvailable, "Schema migrations only apply to MySQL, PostgreSQL and SQLite storage")
+		return
+	}
+	status, err := db.schemaStatus()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Unable to fetch the schema status", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Unable to fetch schema status from DB")
+		return
+	}
+	writeData(w, r, http.StatusOK, status)
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// auditChange : record a successful mapping change. Failures are logged, the change itself already happened.
+func (rh *RequestHandler) auditChange(req *http.Request, action string, before, after *routes) {
+	subject := after
+	if subject == nil {
+		subject = before
+	}
+	rec := &auditRecord{
+		Identifier: subject.Identifier,
+		RouteType:  subject.RouteType,
+		Action:     action,
+		Actor:      requestActor(req),
+		SourceIP:   sourceIP(req),
+		ChangedAt:  time.Now().UTC(),
+		Before:     newAuditValues(before),
+		After:      newAuditValues(after),
+	}
+	if err := rh.dbConn.recordAudit(rec); err != nil {
+		slog.ErrorContext(req.Context(), "Unable to record audit entry", "route_type", rec.RouteType, "identifier", rec.Identifier, "error", err)
+	}
+}
+
+// auditTest : record a test alert sent through a mapping
+func (rh *RequestHandler) auditTest(req *http.Request, route *routes, steps []testStep) {
+	values := newAuditValues(route)
+	for _, step := range steps {
+		step.Body = ""
+		values.Test = append(values.Test, step)
+	}
+	rec := &auditRecord{
+		Identifier: route.Identifier,
+		RouteType:  route.RouteType,
+		Action:     auditTest,
+		Actor:      requestActor(req),
+		SourceIP:   sourceIP(req),
+		ChangedAt:  time.Now().UTC(),
+		After:      values,
+	}
+	if err := rh.dbConn.recordAudit(rec); err != nil {
+		slog.ErrorContext(req.Context(), "Unable to record audit entry", "route_type", rec.RouteType, "identifier", rec.Identifier, "error", err)
+	}
+}
+
+//MappingHistory : audit trail of a single mapping
+func (rh *RequestHandler) MappingHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if vars["type"] != teamsType && vars["type"] != pagerdutyType {
+		writeError(w, r, http.StatusNotAcceptable, codeInvalidType, "Not a valid Type in the request.")
+		return
+	}
+	filter, ok := parseAuditFilter(w, r)
+	if !ok {
+		return
+	}
+	filter.Identifier = vars["identifier"]
+	filter.RouteType = vars["type"]
+	rh.writeAudit(w, r, filter)
+}
+
+//ListAudit : audit trail of all mappings, optionally restricted with since/until (RFC 3339) and limit
+func (rh *RequestHandler) ListAudit(w http.ResponseWriter, r *http.Request) {
+	filter, ok := parseAuditFilter(w, r)
+	if !ok {
+		return
+	}
+	rh.writeAudit(w, r, filter)
+}
+
+func (rh *RequestHandler) writeAudit(w http.ResponseWriter, r *http.Request, filter auditFilter) {
+	records, err := rh.dbConn.listAudit(filter)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Unable to fetch the audit trail", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Unable to fetch audit trail from DB")
+		return
+	}
+	if records == nil {
+		records = []*auditRecord{}
+	}
+	writeData(w, r, http.StatusOK, records)
+}
+
+// parseAuditFilter : read since, until and limit query parameters
+func parseAuditFilter(w http.ResponseWriter, r *http.Request) (auditFilter, bool) {
+	filter := auditFilter{
+		Since: time.Unix(0, 0).UTC(),
+		Until: time.Date(9999, 12, 31, 0, 0, 0, 0, time.UTC),
+		Limit: defaultAuditLimit,
+	}
+	query := r.URL.Query()
+	for name, target := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if value := query.Get(name); value != "" {
+			parsed, err := time.Parse(time.RFC3339, value)
+			if err != nil {
+				writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid "+name+" parameter. Use RFC 3339 e.g. 2019-07-01T00:00:00Z")
+				return filter, false
+			}
+			*target = parsed.UTC()
+		}
+	}
+	if value := query.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid limit parameter")
+			return filter, false
+		}
+		if limit > maxAuditLimit {
+			limit = maxAuditLimit
+		}
+		filter.Limit = limit
+	}
+	return filter, true
+}
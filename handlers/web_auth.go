@@ -0,0 +1,80 @@
+package handlers
+
+import (
+	"context"
+	"crypto/subtle"
+	"net"
+	"net/http"
+	"strings"
+)
+
+type contextKey string
+
+const actorKey contextKey = "actor"
+
+// anonymousActor is recorded when no admin_tokens are configured
+const anonymousActor = "anonymous"
+
+//RequireAdmin : guard management endpoints with the bearer tokens listed under admin_tokens
+func (rh *RequestHandler) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		actor := anonymousActor
+		applConfig := rh.applConfig()
+		if len(applConfig.AdminTokens) > 0 {
+			name, ok := applConfig.tokenOwner(bearerToken(r))
+			if !ok {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="eventalert-integration"`)
+				writeError(w, r, http.StatusUnauthorized, codeUnauthorized, "Missing or invalid admin token")
+				return
+			}
+			actor = name
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), actorKey, actor)))
+	}
+}
+
+// tokenOwner : name of the admin owning the given token
+func (applConfig *applicationConfig) tokenOwner(token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	for _, admin := range applConfig.AdminTokens {
+		if subtle.ConstantTimeCompare([]byte(admin.Token), []byte(token)) == 1 {
+			return admin.Name, true
+		}
+	}
+	return "", false
+}
+
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// requestActor : the admin authenticated by RequireAdmin
+func requestActor(r *http.Request) string {
+	if actor, ok := r.Context().Value(actorKey).(string); ok {
+		return actor
+	}
+	return anonymousActor
+}
+
+// sourceIP : client address as seen by the gorouter. The gorouter appends the address it was
+// called from to X-Forwarded-For, so only the rightmost entry can be trusted, the ones before it
+// are whatever the client sent.
+func sourceIP(r *http.Request) string {
+	if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+		entries := strings.Split(forwarded[len(forwarded)-1], ",")
+		if last := strings.TrimSpace(entries[len(entries)-1]); last != "" {
+			return last
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
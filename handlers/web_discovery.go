@@ -0,0 +1,267 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	mediaText = "text/plain"
+	mediaJSON = "application/json"
+	mediaHTML = "text/html"
+)
+
+// discovery : what the root route answers to Accept: application/json
+type discovery struct {
+	Name        string            `json:"name"`
+	Version     string            `json:"version"`
+	StorageMode string            `json:"storageMode"`
+	Types       []string          `json:"types"`
+	Features    map[string]bool   `json:"features"`
+	OpenAPI     string            `json:"openapi"`
+	Routes      []discoveredRoute `json:"routes"`
+}
+
+type discoveredRoute struct {
+	Path       string   `json:"path"`
+	Methods    []string `json:"methods"`
+	Deprecated bool     `json:"deprecated,omitempty"`
+}
+
+// webhookHelp : a mapping of the help page, with the URL to paste in Event Alerts
+type webhookHelp struct {
+	RouteType   string
+	Identifier  string
+	Description string
+	URL         string
+}
+
+//Discovery : the root route. JSON lists the routes, storage mode, version and features, HTML is a
+//help page with the Event Alerts webhook URL of every mapping, anything else the plain text listing.
+func (rh *RequestHandler) Discovery(router *mux.Router, version string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Vary", "Accept")
+		switch preferredType(r.Header.Get("Accept"), mediaText, mediaJSON, mediaHTML) {
+		case mediaJSON:
+			writeData(w, r, http.StatusOK, rh.discovery(router, version))
+		case mediaHTML:
+			rh.writeHelpPage(w, r, version)
+		default:
+			writeRouteListing(w, router)
+		}
+	}
+}
+
+func (rh *RequestHandler) discovery(router *mux.Router, version string) discovery {
+	legacy := make(map[string]bool)
+	for _, route := range rh.APIRoutes() {
+		legacy[route.Legacy] = true
+	}
+	doc := discovery{
+		Name:        serviceName,
+		Version:     version,
+		StorageMode: rh.storageMode,
+		Types:       strings.Split(supportedTypes, ","),
+		Features:    rh.features(),
+		OpenAPI:     APIPrefix + "/openapi.json",
+		Routes:      []discoveredRoute{},
+	}
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		methods, errMethods := route.GetMethods()
+		if err != nil || errMethods != nil {
+			// The /api/v1 prefix only groups the routes below it
+			return nil
+		}
+		doc.Routes = append(doc.Routes, discoveredRoute{Path: path, Methods: methods, Deprecated: legacy[path]})
+		return nil
+	})
+	return doc
+}
+
+// features : the optional behaviour switched on by application.yml and the storage mode
+func (rh *RequestHandler) features() map[string]bool {
+	applConfig := rh.applConfig()
+	_, cached := rh.dbConn.(*resilientDB)
+	exporter := strings.ToLower(applConfig.Tracing.Exporter)
+	return map[string]bool{
+		"adminAuth":         len(applConfig.AdminTokens) > 0,
+		"mappingCache":      cached,
+		"schemaMigrations":  rh.DBinUse(),
+		"mappingsFile":      !rh.DBinUse() && applConfig.MappingsFile != "",
+		"configReload":      rh.configPath != "",
+		"destinationChecks": applConfig.Health.CheckDestinations,
+		"tracing":           exporter != "" && exporter != tracingNone,
+		"deliverySpool":     applConfig.Shutdown.SpoolFile != "",
+	}
+}
+
+// writeRouteListing : the plain text route listing the root route always answered, routes
+// without methods included
+func writeRouteListing(w http.ResponseWriter, router *mux.Router) {
+	// Print the routes and help information for app usages
+	fmt.Fprintln(w, "{type} ==> teams or pagerduty")
+	fmt.Fprintln(w, "{identifier} ==> unique tag for respective teams/pagerduty endpoint")
+	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		pathTemplate, err := route.GetPathTemplate()
+		if err == nil {
+			fmt.Fprintf(w, "ROUTE \"%s\" is servicing ", pathTemplate)
+		}
+		methods, err := route.GetMethods()
+		if err == nil {
+			fmt.Fprintf(w, "on HTTP method %s", strings.Join(methods, ","))
+		}
+		fmt.Fprintln(w)
+		return nil
+	})
+}
+
+// writeHelpPage : the webhooks are listed to admins only once admin_tokens are set, the
+// identifiers are all it takes to post alerts
+func (rh *RequestHandler) writeHelpPage(w http.ResponseWriter, r *http.Request, version string) {
+	var routeEntries []*routes
+	var err error
+	applConfig := rh.applConfig()
+	restricted := false
+	if len(applConfig.AdminTokens) > 0 {
+		_, admin := applConfig.tokenOwner(bearerToken(r))
+		restricted = !admin
+	}
+	if !restricted {
+		routeEntries, err = rh.dbConn.listRoutes()
+	}
+	unavailable := err != nil
+	if unavailable {
+		slog.ErrorContext(r.Context(), "Unable to fetch the list of route mapping", "error", err)
+	}
+	base := baseURL(r)
+	webhooks := make([]webhookHelp, 0, len(routeEntries))
+	for _, route := range routeEntries {
+		webhooks = append(webhooks, webhookHelp{
+			RouteType:   route.RouteType,
+			Identifier:  route.Identifier,
+			Description: route.Description,
+			URL:         base + APIPrefix + "/alerts/" + route.RouteType + "/" + route.Identifier,
+		})
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		if webhooks[i].Identifier != webhooks[j].Identifier {
+			return webhooks[i].Identifier < webhooks[j].Identifier
+		}
+		return webhooks[i].RouteType < webhooks[j].RouteType
+	})
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err = helpPage.Execute(w, map[string]interface{}{
+		"Name":        serviceName,
+		"Version":     version,
+		"StorageMode": rh.storageMode,
+		"Base":        base,
+		"OpenAPI":     base + APIPrefix + "/openapi.json",
+		"Webhooks":    webhooks,
+		"Unavailable": unavailable,
+		"Restricted":  restricted,
+	})
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Unable to render the help page", "error", err)
+	}
+}
+
+// baseURL : scheme and host the caller reached the app on, behind the gorouter too
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = strings.TrimSpace(strings.Split(proto, ",")[0])
+	}
+	return scheme + "://" + r.Host
+}
+
+// preferredType : the offer ranked highest by the Accept header, the first offer when several rank
+// the same or the header is absent
+func preferredType(accept string, offers ...string) string {
+	if strings.TrimSpace(accept) == "" {
+		return offers[0]
+	}
+	best, bestQ := "", 0.0
+	for _, offer := range offers {
+		if q := acceptQuality(accept, offer); q > bestQ {
+			best, bestQ = offer, q
+		}
+	}
+	if best == "" {
+		return offers[0]
+	}
+	return best
+}
+
+// acceptQuality : q value the most specific range of accept matching offer gives it, 0 when none does
+func acceptQuality(accept string, offer string) float64 {
+	q, specificity := 0.0, -1
+	for _, mediaRange := range strings.Split(accept, ",") {
+		params := strings.Split(mediaRange, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		rangeQ := 1.0
+		for _, param := range params[1:] {
+			if value := strings.TrimSpace(param); strings.HasPrefix(value, "q=") {
+				if parsed, err := strconv.ParseFloat(value[2:], 64); err == nil {
+					rangeQ = parsed
+				}
+			}
+		}
+		level := -1
+		switch {
+		case name == offer:
+			level = 2
+		case name == strings.SplitN(offer, "/", 2)[0]+"/*":
+			level = 1
+		case name == "*/*":
+			level = 0
+		}
+		if level > specificity {
+			q, specificity = rangeQ, level
+		}
+	}
+	return q
+}
+
+// helpPage : self-contained, nothing is loaded from outside the app
+var helpPage = template.Must(template.New("help").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 60em; padding: 0 1em; color: #222; }
+table { border-collapse: collapse; width: 100%; }
+th, td { text-align: left; padding: .4em .6em; border-bottom: 1px solid #ddd; vertical-align: top; }
+code { background: #f4f4f4; padding: .1em .3em; user-select: all; word-break: break-all; }
+.muted { color: #666; }
+</style>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p class="muted">Version {{.Version}}, {{.StorageMode}} storage</p>
+<h2>Event Alerts webhooks</h2>
+<p>Add the URL of a mapping as a webhook target in PCF Event Alerts.</p>
+{{if .Restricted}}<p>The mappings are only listed with an admin token. Sign in to the <a href="{{.Base}}/ui/">admin UI</a> to see their webhook URLs.</p>
+{{else if .Unavailable}}<p>The mappings could not be read, check the logs.</p>
+{{else if .Webhooks}}<table>
+<tr><th>Identifier</th><th>Type</th><th>Webhook URL</th><th>Description</th></tr>
+{{range .Webhooks}}<tr><td>{{.Identifier}}</td><td>{{.RouteType}}</td><td><code>{{.URL}}</code></td><td>{{.Description}}</td></tr>
+{{end}}</table>
+{{else}}<p>No mapping yet. Create one with <code>PUT {{.Base}}/api/v1/mappings/teams/&lt;identifier&gt;</code>.</p>
+{{end}}<h2>API</h2>
+<p>Mappings can be managed and test-fired in the <a href="{{.Base}}/ui/">admin UI</a>.</p>
+<p>The management API is described in <a href="{{.OpenAPI}}">{{.OpenAPI}}</a>. Ask for <code>Accept: application/json</code> on this page for the routes and enabled features.</p>
+</body>
+</html>
+`))
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+)
+
+// readiness : body of the readiness endpoint
+type readiness struct {
+	Status       string             `json:"status"`
+	StorageMode  string             `json:"storageMode"`
+	Database     *dbHealth          `json:"database,omitempty"`
+	Config       configStatus       `json:"config"`
+	Deliveries   deliveryBacklog    `json:"deliveries"`
+	Destinations []destinationCheck `json:"destinations,omitempty"`
+}
+
+// deliveryBacklog : deliveries still waiting on Teams or PagerDuty
+type deliveryBacklog struct {
+	InFlight int64 `json:"inFlight"`
+}
+
+//Liveness : GET request answering 200 as long as the process serves requests. Meant for the
+//CF http health check, which restarts the app when it fails.
+func (rh *RequestHandler) Liveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+//Readiness : GET request reporting whether the app can serve and change mappings, with the state
+//of its dependencies. Answers 503 while the database is down, lookups are then still served
+//from the cache, and once shutdown started. A failed config reload or an unreachable
+//destination is reported only.
+func (rh *RequestHandler) Readiness(w http.ResponseWriter, r *http.Request) {
+	ready := readiness{
+		Status:       "ready",
+		StorageMode:  rh.storageMode,
+		Config:       rh.configStatus(),
+		Deliveries:   deliveryBacklog{InFlight: rh.metrics.pending.Load()},
+		Destinations: rh.destinations.checks(),
+	}
+	if health, ok := rh.databaseHealth(r.Context()); ok {
+		ready.Database = &health
+	}
+	status := http.StatusOK
+	if ready.Database != nil && ready.Database.Status != "up" {
+		ready.Status, status = "degraded", http.StatusServiceUnavailable
+	}
+	if rh.deliveries.draining.Load() {
+		ready.Status, status = "draining", http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ready)
+}
+
+// databaseHealth : state of the SQL database, false in memory storage mode
+func (rh *RequestHandler) databaseHealth(ctx context.Context) (dbHealth, bool) {
+	switch db := rh.dbConn.(type) {
+	case *resilientDB:
+		return db.health(), true
+	case *sqlDB:
+		ctx, cancel := context.WithTimeout(ctx, dbPingTimeout)
+		defer cancel()
+		if err := db.conn.PingContext(ctx); err != nil {
+			return dbHealth{Status: "down", Error: err.Error()}, true
+		}
+		return dbHealth{Status: "up"}, true
+	}
+	return dbHealth{}, false
+}
+
+//CacheStatus : GET request reporting the size, age and hit rate of the mapping cache
+func (rh *RequestHandler) CacheStatus(w http.ResponseWriter, r *http.Request) {
+	db, ok := rh.dbConn.(*resilientDB)
+	if !ok {
+		writeError(w, r, http.StatusNotFound, codeNotAvailable, "Mappings are only cached in front of MySQL and PostgreSQL")
+		return
+	}
+	writeData(w, r, http.StatusOK, db.stats())
+}
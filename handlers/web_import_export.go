@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	importMerge   = "merge"
+	importReplace = "replace"
+
+	outcomeCreated   = "created"
+	outcomeUpdated   = "updated"
+	outcomeUnchanged = "unchanged"
+	outcomeDeleted   = "deleted"
+	outcomeInvalid   = "invalid"
+
+	// maxImportSize caps the import body at 1 MiB
+	maxImportSize = 1 << 20
+	// maxIdentifierLength matches the identifier column of route_mapping
+	maxIdentifierLength = 30
+)
+
+// routeExport : same layout as the notifications block of application.yml
+type routeExport struct {
+	Notifications []notification `yaml:"notifications" json:"notifications"`
+}
+
+// importReport : per-entry outcome of an import
+type importReport struct {
+	Mode    string         `json:"mode"`
+	DryRun  bool           `json:"dryRun"`
+	Applied bool           `json:"applied"`
+	Entries []*importEntry `json:"entries"`
+
+	upserts, deletes []*routes
+	previous         map[string]*routes
+	invalid          bool
+}
+
+type importEntry struct {
+	Identifier string `json:"identifier"`
+	RouteType  string `json:"routeType,omitempty"`
+	Outcome    string `json:"outcome"`
+	Error      string `json:"error,omitempty"`
+}
+
+//ExportMappings : dump every mapping as YAML, or JSON with ?format=json. Under /api/v1 JSON is
+//the default and ?format=yaml asks for YAML.
+func (rh *RequestHandler) ExportMappings(w http.ResponseWriter, r *http.Request) {
+	routeEntries, err := rh.dbConn.listRoutes()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Unable to export the route mappings", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Unable to fetch route mapping from DB")
+		return
+	}
+	export := routeExport{Notifications: notificationsFromRoutes(routeEntries)}
+
+	format := r.URL.Query().Get("format")
+	if format == "json" || strings.Contains(r.Header.Get("Accept"), "application/json") || (isAPIv1(r) && format != "yaml") {
+		writeData(w, r, http.StatusOK, export)
+		return
+	}
+	enc, err := marshalYAML(export)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Unable to encode the route mappings", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Unable to encode route mapping")
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-yaml")
+	w.Write(enc)
+}
+
+//ImportMappings : validate a YAML/JSON export and apply it in one transaction.
+//mode=merge (default) keeps mappings missing from the body, mode=replace removes them. dry_run=true only reports.
+func (rh *RequestHandler) ImportMappings(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	mode := query.Get("mode")
+	if mode == "" {
+		mode = importMerge
+	}
+	if mode != importMerge && mode != importReplace {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Not a valid mode. Use merge or replace")
+		return
+	}
+	dryRun := false
+	if value := query.Get("dry_run"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Not a valid dry_run flag")
+			return
+		}
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxImportSize))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Unable to read the import request")
+		return
+	}
+	// JSON is valid YAML, so a single decoder serves both formats.
+	var doc routeExport
+	if err := yaml.Unmarshal(body, &doc); err != nil {
+		slog.WarnContext(r.Context(), "Invalid import request", "error", err)
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid YAML/JSON Request. Please verify and resubmit")
+		return
+	}
+
+	current, err := rh.dbConn.listRoutes()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Unable to fetch the list of route mapping", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Unable to fetch route mapping from DB")
+		return
+	}
+	report := planImport(doc.Notifications, current, mode)
+	report.DryRun = dryRun
+	if report.invalid {
+		writeImportReport(w, r, report, http.StatusBadRequest)
+		return
+	}
+	if !dryRun {
+		err := rh.dbConn.applyRoutes(report.upserts, report.deletes)
+		if err == errDatabaseUnavailable {
+			writeUnavailable(w, r)
+			return
+		}
+		if err != nil {
+			slog.ErrorContext(r.Context(), "Unable to apply the import", "error", err)
+			writeError(w, r, http.StatusInternalServerError, codeInternal, "Internal server error. Please check the logs for more information")
+			return
+		}
+		report.Applied = true
+		rh.auditImport(r, report)
+		slog.InfoContext(r.Context(), "Imported route mappings", "imported", len(report.upserts), "removed", len(report.deletes), "mode", mode)
+	}
+	writeImportReport(w, r, report, http.StatusOK)
+}
+
+// planImport : validate every entry and work out what applying it would change
+func planImport(notifications []notification, current []*routes, mode string) *importReport {
+	report := &importReport{Mode: mode, Entries: []*importEntry{}, previous: make(map[string]*routes)}
+	for _, rt := range current {
+		report.previous[routeKey(rt)] = rt
+	}
+
+	seen := make(map[string]bool)
+	for _, notify := range notifications {
+		if err := validateNotification(notify, seen); err != nil {
+			report.invalid = true
+			report.Entries = append(report.Entries, &importEntry{Identifier: notify.Name, Outcome: outcomeInvalid, Error: err.Error()})
+			continue
+		}
+		for _, rt := range notify.routes() {
+			seen[routeKey(rt)] = true
+			entry := &importEntry{Identifier: rt.Identifier, RouteType: rt.RouteType, Outcome: outcomeCreated}
+			if existing, found := report.previous[routeKey(rt)]; found {
+				entry.Outcome = outcomeUnchanged
+				if existing.PostURL != rt.PostURL || existing.Description != rt.Description {
+					entry.Outcome = outcomeUpdated
+				}
+			}
+			if entry.Outcome != outcomeUnchanged {
+				report.upserts = append(report.upserts, rt)
+			}
+			report.Entries = append(report.Entries, entry)
+		}
+	}
+
+	if mode == importReplace {
+		for _, rt := range current {
+			if !seen[routeKey(rt)] {
+				report.deletes = append(report.deletes, rt)
+				report.Entries = append(report.Entries, &importEntry{Identifier: rt.Identifier, RouteType: rt.RouteType, Outcome: outcomeDeleted})
+			}
+		}
+	}
+	return report
+}
+
+// validateNotification : checks one import entry with the same rules as application.yml. seen tracks names already imported.
+func validateNotification(notify notification, seen map[string]bool) error {
+	if seen[notify.Name+"/"+teamsType] || seen[notify.Name+"/"+pagerdutyType] {
+		return fmt.Errorf("name %s is listed more than once", notify.Name)
+	}
+	if problems := checkNotification(notify); len(problems) > 0 {
+		return configErrors(problems)
+	}
+	return nil
+}
+
+// auditImport : one audit record per mapping the import changed
+func (rh *RequestHandler) auditImport(r *http.Request, report *importReport) {
+	for _, rt := range report.upserts {
+		before := report.previous[routeKey(rt)]
+		action := auditUpdate
+		if before == nil {
+			action = auditCreate
+		}
+		after, err := rh.dbConn.getRoute(r.Context(), rt.Identifier, rt.RouteType)
+		if err != nil {
+			after = rt
+		}
+		rh.auditChange(r, action, before, after)
+	}
+	for _, rt := range report.deletes {
+		rh.auditChange(r, auditDelete, rt, nil)
+	}
+}
+
+// writeImportReport : an import with invalid entries is an invalid_import error under /api/v1,
+// the report is its details
+func writeImportReport(w http.ResponseWriter, r *http.Request, report *importReport, status int) {
+	if report.invalid && isAPIv1(r) {
+		writeAPIError(w, r, apiError{Code: codeInvalidImport, Message: "Some entries of the import are invalid, nothing was applied", Details: report})
+		return
+	}
+	writeData(w, r, status, report)
+}
+
+func routeKey(rt *routes) string {
+	return rt.Identifier + "/" + rt.RouteType
+}
@@ -1,31 +1,25 @@
 package handlers
 
 import (
-	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
 )
 
 //ListMappings : Default landing to provide list of existing mappings and sample requests
 func (rh *RequestHandler) ListMappings(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	var routes []*routes
-	var err error
-	if rh.applConfig.EnableMysql {
-		routes, err = rh.dbConn.listRoutes()
-		if err != nil {
-			log.Printf("Unable to fetch the list of route mapping. %s\n", err)
-			http.Error(w, "Unable to fetch route mapping from DB", http.StatusInternalServerError)
-			return
-		}
-	} else {
-		routes, err = rh.applConfig.listRoutes()
-		if err != nil {
-			log.Printf("Unable to fetch the list of route mapping. %s\n", err)
-			http.Error(w, "Unable to fetch route mapping from DB", http.StatusInternalServerError)
-			return
-		}
+	routeEntries, err := rh.dbConn.listRoutes()
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Unable to fetch the list of route mapping", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Unable to fetch route mapping from DB")
+		return
 	}
-	w.WriteHeader(http.StatusCreated)
-	json.NewEncoder(w).Encode(routes)
+	if routeEntries == nil {
+		routeEntries = []*routes{}
+	}
+	// The legacy path answers 201, as it always did
+	status := http.StatusCreated
+	if isAPIv1(r) {
+		status = http.StatusOK
+	}
+	writeData(w, r, status, routeEntries)
 }
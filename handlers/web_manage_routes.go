@@ -2,10 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
-	"net/url"
+	"strconv"
 	"strings"
 
 	"github.com/gorilla/mux"
@@ -16,26 +15,30 @@ type requestJSON struct {
 	Description string `json:"description,omitempty"`
 }
 
+// patchJSON : partial update body, absent fields are left untouched
+type patchJSON struct {
+	URL         *string `json:"URL,omitempty"`
+	Description *string `json:"description,omitempty"`
+}
+
 const (
 	supportedTypes = "teams,pagerduty"
 )
 
-//CreatMapping PUT request to create new mapping into the route_mapping table
+//CreatMapping PUT request to create or replace a mapping in the route_mapping table
 func (rh *RequestHandler) CreatMapping(wr http.ResponseWriter, req *http.Request) {
-
-	fmt.Printf("Received a PUT request. Creating new route mapping entry.")
 	vars := mux.Vars(req)
-	if !strings.Contains(supportedTypes, vars["type"]) {
-		log.Printf("Invalid Entry type received. Type received: " + vars["type"])
+	if vars["type"] != teamsType && vars["type"] != pagerdutyType {
+		slog.WarnContext(req.Context(), "Invalid Entry type received", "route_type", vars["type"])
 		// Write an error and stop the handler chain
-		http.Error(wr, "Not a valid Type.", http.StatusNotAcceptable)
+		writeError(wr, req, http.StatusNotAcceptable, codeInvalidType, "Not a valid Type.")
 		return
 	}
 	decoder := json.NewDecoder(req.Body)
 	var reqJSON requestJSON
 	if err := decoder.Decode(&reqJSON); err != nil {
-		log.Printf("Invalid PUT Request")
-		http.Error(wr, "Invalid JSON Request. Please verify and resubmit", http.StatusNotAcceptable)
+		slog.WarnContext(req.Context(), "Invalid PUT Request", "error", err)
+		writeError(wr, req, http.StatusNotAcceptable, codeInvalidRequest, "Invalid JSON Request. Please verify and resubmit")
 		return
 	}
 	route := &routes{
@@ -44,44 +47,211 @@ func (rh *RequestHandler) CreatMapping(wr http.ResponseWriter, req *http.Request
 		PostURL:     reqJSON.URL,
 		Description: reqJSON.Description,
 	}
+	if !validRouteURL(wr, req, route) {
+		return
+	}
 
-	if route.RouteType == "teams" {
-		_, err := url.ParseRequestURI(route.PostURL)
-		if err != nil {
-			log.Printf("Invalid URL received in PUT Request for Teams")
-			http.Error(wr, "Invalid URL received for Teams. Please verify and resubmit", http.StatusNotAcceptable)
-			return
+	ifMatch, ok := parseIfMatch(wr, req)
+	if !ok {
+		return
+	}
+	existing, err := rh.dbConn.latestRoute(req.Context(), route.Identifier, route.RouteType)
+	action := auditUpdate
+	switch {
+	case err == errRouteNotFound && ifMatch != 0:
+		writeError(wr, req, http.StatusConflict, codePreconditionFailed, "Mapping does not exist. Remove If-Match to create it")
+		return
+	case err == errRouteNotFound:
+		action, existing = auditCreate, nil
+		err = rh.dbConn.addRoute(route)
+	case err == nil:
+		version := existing.Version
+		if ifMatch != 0 {
+			version = ifMatch
 		}
+		err = rh.dbConn.updateRoute(route, version)
 	}
-
-	if err := rh.dbConn.addRoute(route); err != nil {
-		log.Printf("Unable to add given route mapping Identifier:" + route.Identifier + " Type:" + route.RouteType)
-		log.Println(err)
-		http.Error(wr, "Internal server error. Please check the logs for more information", http.StatusInternalServerError)
+	if !rh.writeSavedRoute(wr, req, action, existing, route, err) {
 		return
 	}
-	//wr.Header().Set("Content-Type", "application/json")
-	wr.WriteHeader(http.StatusOK)
-	fmt.Printf("Successfully added a new mapping entry for " + route.Identifier + " with type as " + route.RouteType)
+	slog.InfoContext(req.Context(), "Successfully saved mapping entry", "identifier", route.Identifier, "route_type", route.RouteType)
 	return
 }
 
+//PatchMapping PATCH request to partially update an existing mapping
+func (rh *RequestHandler) PatchMapping(wr http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	if vars["type"] != teamsType && vars["type"] != pagerdutyType {
+		slog.WarnContext(req.Context(), "Invalid Entry type received for update", "route_type", vars["type"])
+		writeError(wr, req, http.StatusNotAcceptable, codeInvalidType, "Not a valid Type in the request.")
+		return
+	}
+	var patch patchJSON
+	if err := json.NewDecoder(req.Body).Decode(&patch); err != nil {
+		slog.WarnContext(req.Context(), "Invalid PATCH Request", "error", err)
+		writeError(wr, req, http.StatusNotAcceptable, codeInvalidRequest, "Invalid JSON Request. Please verify and resubmit")
+		return
+	}
+	ifMatch, ok := parseIfMatch(wr, req)
+	if !ok {
+		return
+	}
+
+	route, err := rh.dbConn.latestRoute(req.Context(), vars["identifier"], vars["type"])
+	if err == errRouteNotFound {
+		writeError(wr, req, http.StatusNotFound, codeMappingNotFound, "Mapping not found for "+vars["identifier"])
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Unable to fetch route mapping", "identifier", vars["identifier"], "route_type", vars["type"], "error", err)
+		writeError(wr, req, http.StatusInternalServerError, codeInternal, "Internal server error. Please check the logs for more information")
+		return
+	}
+	version := route.Version
+	if ifMatch != 0 {
+		version = ifMatch
+	}
+	before := *route
+	if patch.URL != nil {
+		route.PostURL = *patch.URL
+	}
+	if patch.Description != nil {
+		route.Description = *patch.Description
+	}
+	if !validRouteURL(wr, req, route) {
+		return
+	}
+
+	if !rh.writeSavedRoute(wr, req, auditUpdate, &before, route, rh.dbConn.updateRoute(route, version)) {
+		return
+	}
+	slog.InfoContext(req.Context(), "Successfully updated mapping", "identifier", route.Identifier, "route_type", route.RouteType)
+}
+
+//GetMapping GET request to fetch a single mapping along with its ETag
+func (rh *RequestHandler) GetMapping(wr http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	route, err := rh.dbConn.latestRoute(req.Context(), vars["identifier"], vars["type"])
+	if err != nil {
+		writeError(wr, req, http.StatusNotFound, codeMappingNotFound, "Mapping not found for "+vars["identifier"])
+		return
+	}
+	writeRoute(wr, req, route, http.StatusOK)
+}
+
+// writeSavedRoute : translate the outcome of an add/update into a response and audit record. Returns true on success.
+func (rh *RequestHandler) writeSavedRoute(wr http.ResponseWriter, req *http.Request, action string, before, route *routes, err error) bool {
+	switch err {
+	case nil:
+	case errRouteNotFound:
+		writeError(wr, req, http.StatusNotFound, codeMappingNotFound, "Mapping not found for "+route.Identifier)
+		return false
+	case errRouteExists, errVersionConflict:
+		slog.WarnContext(req.Context(), "Conflicting update on mapping", "identifier", route.Identifier, "route_type", route.RouteType)
+		writeError(wr, req, http.StatusConflict, codeVersionConflict, "Mapping was changed by another request. Fetch it again and retry")
+		return false
+	case errDatabaseUnavailable:
+		writeUnavailable(wr, req)
+		return false
+	default:
+		slog.ErrorContext(req.Context(), "Unable to save given route mapping", "identifier", route.Identifier, "route_type", route.RouteType, "error", err)
+		writeError(wr, req, http.StatusInternalServerError, codeInternal, "Internal server error. Please check the logs for more information")
+		return false
+	}
+	// The legacy paths answer 200 for a creation too
+	status := http.StatusOK
+	if action == auditCreate && isAPIv1(req) {
+		status = http.StatusCreated
+	}
+	saved, err := rh.dbConn.latestRoute(req.Context(), route.Identifier, route.RouteType)
+	if err != nil {
+		// The write went through, there is just nothing fresh to echo back.
+		rh.auditChange(req, action, before, route)
+		if isAPIv1(req) {
+			writeData(wr, req, status, route)
+		} else {
+			wr.WriteHeader(status)
+		}
+		return true
+	}
+	rh.auditChange(req, action, before, saved)
+	writeRoute(wr, req, saved, status)
+	return true
+}
+
+// writeUnavailable : reject a change while the database is down, mappings are read-only until it is back
+func writeUnavailable(wr http.ResponseWriter, req *http.Request) {
+	wr.Header().Set("Retry-After", strconv.Itoa(int(dbHealthInterval.Seconds())))
+	writeError(wr, req, http.StatusServiceUnavailable, codeDatabaseUnavailable, "Database is unavailable, mappings are read-only until it is back. Retry later")
+}
+
+// writeRoute : encode a single mapping as JSON with its version as ETag
+func writeRoute(wr http.ResponseWriter, req *http.Request, route *routes, status int) {
+	wr.Header().Set("ETag", `"`+strconv.FormatInt(route.Version, 10)+`"`)
+	writeData(wr, req, status, route)
+}
+
+// parseIfMatch : read the expected version from If-Match. Zero means no precondition.
+func parseIfMatch(wr http.ResponseWriter, req *http.Request) (int64, bool) {
+	etag := req.Header.Get("If-Match")
+	if etag == "" || etag == "*" {
+		return 0, true
+	}
+	etag = strings.Trim(strings.TrimPrefix(etag, "W/"), `"`)
+	version, err := strconv.ParseInt(etag, 10, 64)
+	if err != nil || version < 1 {
+		writeError(wr, req, http.StatusBadRequest, codeInvalidRequest, "Invalid If-Match header. Use the ETag returned for the mapping")
+		return 0, false
+	}
+	return version, true
+}
+
+// validRouteURL : Teams mappings must carry a valid webhook URL, PagerDuty ones an integration key
+func validRouteURL(wr http.ResponseWriter, req *http.Request, route *routes) bool {
+	if route.RouteType == teamsType && !validWebhookURL(route.PostURL) {
+		slog.WarnContext(req.Context(), "Invalid URL received in Request for Teams", "identifier", route.Identifier)
+		writeError(wr, req, http.StatusNotAcceptable, codeInvalidMapping, "Invalid URL received for Teams. Please verify and resubmit")
+		return false
+	}
+	if route.RouteType == pagerdutyType && !routingKeyPattern.MatchString(route.PostURL) {
+		slog.WarnContext(req.Context(), "Invalid routing key received in Request for PagerDuty", "identifier", route.Identifier)
+		writeError(wr, req, http.StatusNotAcceptable, codeInvalidMapping, "Invalid routing key received for PagerDuty. Use the 32 character integration key")
+		return false
+	}
+	return true
+}
+
 //RemoveMapping DELETE request to remove the mapping from route_mapping table
 func (rh *RequestHandler) RemoveMapping(wr http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
-	if !strings.Contains(supportedTypes, vars["type"]) {
-		log.Printf("Invalid Entry type received for removal: " + vars["type"])
+	if vars["type"] != teamsType && vars["type"] != pagerdutyType {
+		slog.WarnContext(req.Context(), "Invalid Entry type received for removal", "route_type", vars["type"])
 		// Write an error and stop the handler chain
-		http.Error(wr, "Not a valid Type in the request.", http.StatusNotAcceptable)
+		writeError(wr, req, http.StatusNotAcceptable, codeInvalidType, "Not a valid Type in the request.")
 		return
 	}
 
-	if err := rh.dbConn.deleteRoute(vars["identifier"], vars["type"]); err != nil {
-		log.Printf("Unable to remove route mapping Identifier:" + vars["identifier"] + " Type:" + vars["type"])
-		log.Println(err)
-		http.Error(wr, "Internal server error. Please check the logs for more information", http.StatusInternalServerError)
+	before, _ := rh.dbConn.latestRoute(req.Context(), vars["identifier"], vars["type"])
+	err := rh.dbConn.deleteRoute(vars["identifier"], vars["type"])
+	if err == errRouteNotFound {
+		writeError(wr, req, http.StatusNotFound, codeMappingNotFound, "Mapping not found for "+vars["identifier"])
 		return
 	}
-	fmt.Printf("Successfully removed " + vars["type"] + " mapping for identifier " + vars["identifier"])
-	return
+	if err == errDatabaseUnavailable {
+		writeUnavailable(wr, req)
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(req.Context(), "Unable to remove route mapping", "identifier", vars["identifier"], "route_type", vars["type"], "error", err)
+		writeError(wr, req, http.StatusInternalServerError, codeInternal, "Internal server error. Please check the logs for more information")
+		return
+	}
+	if before == nil {
+		before = &routes{Identifier: vars["identifier"], RouteType: vars["type"]}
+	}
+	rh.auditChange(req, auditDelete, before, nil)
+	slog.InfoContext(req.Context(), "Successfully removed mapping", "identifier", vars["identifier"], "route_type", vars["type"])
+	if isAPIv1(req) {
+		wr.WriteHeader(http.StatusNoContent)
+	}
 }
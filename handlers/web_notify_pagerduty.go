@@ -2,9 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/tushardag/pcf-eventalert-integration/helpers"
@@ -18,41 +18,43 @@ const (
 func (rh *RequestHandler) PagerDutyAlert(w http.ResponseWriter, r *http.Request) {
 	//pulling mux variable
 	vars := mux.Vars(r)
-
-	var route *routes
-	var err error
-	if rh.applConfig.EnableMysql {
-		route, err = rh.dbConn.getRoute(vars["identifier"], pagerdutyType)
-	} else {
-		route, err = rh.applConfig.getRoute(vars["identifier"], pagerdutyType)
+	defer rh.metrics.observeHandling(pagerdutyType, time.Now())
+	ctx := withLogFields(r.Context(), slog.String("identifier", vars["identifier"]), slog.String("route_type", pagerdutyType))
+	if rh.rejectWhileDraining(w, r) {
+		rh.alertReceived(ctx, pagerdutyType, vars["identifier"], alertRejected, "")
+		return
 	}
 
+	route, err := rh.dbConn.getRoute(ctx, vars["identifier"], pagerdutyType)
+
 	if err != nil {
-		log.Printf("Unable to pull webhook URL for : " + vars["identifier"])
+		rh.alertReceived(ctx, pagerdutyType, vars["identifier"], alertUnmapped, "")
 		// Write an error and stop the handler chain
-		http.Error(w, "Unable to pull webhook URL for "+vars["identifier"]+". Please create the mapping or validate the identifier.", http.StatusPreconditionRequired)
+		writeError(w, r, http.StatusPreconditionRequired, codeMappingNotFound, "Unable to pull webhook URL for "+vars["identifier"]+". Please create the mapping or validate the identifier.")
 		return
 	}
 
 	//Un-marshalling JSON through incoming request from Event Alert
 	incomingMsg := new(helpers.EventAlert)
 	if err := incomingMsg.ParseEventAlert(json.NewDecoder(r.Body)); err != nil {
-		log.Printf("Error in parsing the request object.")
-		log.Println(err)
-		http.Error(w, "Invalid Request", http.StatusBadRequest)
+		slog.WarnContext(ctx, "Error in parsing the request object", "error", err)
+		rh.alertReceived(ctx, pagerdutyType, vars["identifier"], alertInvalid, err.Error())
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid Request")
 		return
 	}
 
-	// fmt.Fprint(w, "Value of Person in function :", incomingMsg)
-	fmt.Printf("%s EventAlert message received for: %s", incomingMsg.Metadata.Status, incomingMsg.Metadata.EventDescription)
-	fmt.Println("Publishing message to PagerDuty " + vars["identifier"] + " for Key# " + route.PostURL)
-	//Building the message body to post a call for MSTeams webhook
-	//Reference fields https://docs.microsoft.com/en-us/outlook/actionable-messages/card-reference
-	// err = helpers.CompilePagerDutyMessage(incomingMsg, route.PostURL).CreateIncident()
-	// if err != nil {
-	// 	log.Printf("Error in opening Incident in PagerDuty: %s\n", err)
-	// 	http.Error(w, "Unable to open Incident in PagerDuty.", http.StatusInternalServerError)
-	// 	return
-	// }
-
+	slog.DebugContext(ctx, "Publishing message to PagerDuty", "status", incomingMsg.Metadata.Status,
+		"event", incomingMsg.Metadata.EventDescription, "routing_key", maskSecret(route.PostURL))
+	answer, err := rh.send(ctx, route, incomingMsg)
+	if rh.writeAborted(ctx, w, r, route, err) {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error in opening Incident in PagerDuty", "error", deliveryError(route, err))
+		rh.alertReceived(ctx, pagerdutyType, vars["identifier"], alertFailed, deliveryError(route, err))
+		writeError(w, r, http.StatusInternalServerError, codeDeliveryFailed, "Unable to open Incident in PagerDuty.")
+		return
+	}
+	rh.alertReceived(ctx, pagerdutyType, vars["identifier"], alertDelivered, "")
+	writeDelivered(w, r, route, answer)
 }
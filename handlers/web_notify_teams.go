@@ -2,10 +2,10 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/tushardag/pcf-eventalert-integration/helpers"
@@ -19,41 +19,68 @@ const (
 func (rh *RequestHandler) MSTeamsAlert(w http.ResponseWriter, r *http.Request) {
 	//pulling mux variable
 	vars := mux.Vars(r)
-	var route *routes
-	var err error
-	if rh.applConfig.EnableMysql {
-		route, err = rh.dbConn.getRoute(vars["identifier"], teamsType)
-	} else {
-		route, err = rh.applConfig.getRoute(vars["identifier"], teamsType)
+	defer rh.metrics.observeHandling(teamsType, time.Now())
+	ctx := withLogFields(r.Context(), slog.String("identifier", vars["identifier"]), slog.String("route_type", teamsType))
+	if rh.rejectWhileDraining(w, r) {
+		rh.alertReceived(ctx, teamsType, vars["identifier"], alertRejected, "")
+		return
 	}
+	route, err := rh.dbConn.getRoute(ctx, vars["identifier"], teamsType)
 	if err != nil {
-		log.Printf("Unable to pull webhook URL for : " + vars["identifier"])
+		rh.alertReceived(ctx, teamsType, vars["identifier"], alertUnmapped, "")
 		// Write an error and stop the handler chain
-		http.Error(w, "Unable to pull webhook URL for "+vars["identifier"]+". Please create the mapping or validate the identifier.", http.StatusPreconditionRequired)
+		writeError(w, r, http.StatusPreconditionRequired, codeMappingNotFound, "Unable to pull webhook URL for "+vars["identifier"]+". Please create the mapping or validate the identifier.")
 		return
 	}
 
 	//Un-marshalling JSON through incoming request from Event Alert
 	incomingMsg := new(helpers.EventAlert)
 	if err := incomingMsg.ParseEventAlert(json.NewDecoder(r.Body)); err != nil {
-		log.Printf("Error in parsing the request object.")
-		log.Println(err)
-		http.Error(w, "Invalid Request", http.StatusBadRequest)
+		slog.WarnContext(ctx, "Error in parsing the request object", "error", err)
+		rh.alertReceived(ctx, teamsType, vars["identifier"], alertInvalid, err.Error())
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid Request")
 		return
 	}
 
-	// fmt.Fprint(w, "Value of Person in function :", incomingMsg)
-	fmt.Printf("EventAlert message received for: %s", incomingMsg.Metadata.EventDescription)
-
 	//Building the message body to post a call for MSTeams webhook
 	//Reference fields https://docs.microsoft.com/en-us/outlook/actionable-messages/card-reference
-	fmt.Println("Publishing message to Teams " + vars["identifier"] + " with URL - " + route.PostURL)
+	slog.DebugContext(ctx, "Publishing message to Teams", "event", incomingMsg.Metadata.EventDescription,
+		"webhook", maskSecret(route.PostURL))
 
-	// if err := helpers.CompileTeamsMessage(incomingMsg).PostMessage(route.PostURL); err != nil {
-	// 	log.Printf("Error in publishing message to Teams: %s\n", err)
-	// 	http.Error(w, "Unable to publish message to Teams", http.StatusInternalServerError)
-	// 	return
-	// }
+	answer, err := rh.send(ctx, route, incomingMsg)
+	if rh.writeAborted(ctx, w, r, route, err) {
+		return
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "Error in publishing message to Teams", "error", deliveryError(route, err))
+		rh.alertReceived(ctx, teamsType, vars["identifier"], alertFailed, deliveryError(route, err))
+		writeError(w, r, http.StatusInternalServerError, codeDeliveryFailed, "Unable to publish message to Teams")
+		return
+	}
+	rh.alertReceived(ctx, teamsType, vars["identifier"], alertDelivered, "")
+	writeDelivered(w, r, route, answer)
+}
+
+// alertDelivery : v1 answer to a delivered alert
+type alertDelivery struct {
+	RouteType  string `json:"routeType"`
+	Identifier string `json:"identifier"`
+	Outcome    string `json:"outcome"`
+	// Status is what Teams or PagerDuty answered
+	Status int `json:"status"`
+}
+
+// writeDelivered : the legacy paths answer an empty 200, /api/v1 reports the delivery
+func writeDelivered(w http.ResponseWriter, r *http.Request, route *routes, answer helpers.DeliveryResult) {
+	if !isAPIv1(r) {
+		return
+	}
+	writeData(w, r, http.StatusOK, alertDelivery{
+		RouteType:  route.RouteType,
+		Identifier: route.Identifier,
+		Outcome:    alertDelivered,
+		Status:     answer.Status,
+	})
 }
 
 //BuildMessage ... building the message based on the incoming msg fields
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/tushardag/pcf-eventalert-integration/helpers"
+)
+
+// pagerDutySeverities : the severities accepted by the PagerDuty Events API v2
+var pagerDutySeverities = []string{"critical", "error", "warning", "info"}
+
+// alertPreview : what an alert would turn into, without anything being sent
+type alertPreview struct {
+	RouteType      string            `json:"routeType"`
+	Identifier     string            `json:"identifier"`
+	Endpoint       string            `json:"endpoint"`
+	MappingVersion int64             `json:"mappingVersion,omitempty"`
+	Decisions      []previewDecision `json:"decisions"`
+	Warnings       []string          `json:"warnings,omitempty"`
+	// Payload is the exact body that would be posted, with the routing key masked
+	Payload json.RawMessage `json:"payload"`
+}
+
+// previewDecision : one value of the payload that is derived rather than copied, and where it came from
+type previewDecision struct {
+	Field  string `json:"field"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+//PreviewAlert : POST request rendering the Teams or PagerDuty payload for an Event Alert body,
+//as the alert endpoints would, without sending it. Secrets are masked.
+func (rh *RequestHandler) PreviewAlert(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if vars["type"] != teamsType && vars["type"] != pagerdutyType {
+		writeError(w, r, http.StatusNotAcceptable, codeInvalidType, "Not a valid Type.")
+		return
+	}
+	incomingMsg := new(helpers.EventAlert)
+	if err := incomingMsg.ParseEventAlert(json.NewDecoder(r.Body)); err != nil {
+		writeError(w, r, http.StatusBadRequest, codeInvalidRequest, "Invalid Request. "+err.Error())
+		return
+	}
+	route, err := rh.dbConn.getRoute(r.Context(), vars["identifier"], vars["type"])
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, codeMappingNotFound, "Mapping not found for "+vars["identifier"]+". Please create the mapping or validate the identifier.")
+		return
+	}
+
+	var preview *alertPreview
+	if route.RouteType == teamsType {
+		preview, err = previewTeams(route, incomingMsg)
+	} else {
+		preview, err = previewPagerDuty(route, incomingMsg)
+	}
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Unable to render the preview", "error", err)
+		writeError(w, r, http.StatusInternalServerError, codeInternal, "Unable to render the preview")
+		return
+	}
+	writeData(w, r, http.StatusOK, preview)
+}
+
+// previewTeams : the MessageCard posted to the webhook of route
+func previewTeams(route *routes, alert *helpers.EventAlert) (*alertPreview, error) {
+	payload, err := json.Marshal(helpers.CompileTeamsMessage(alert))
+	if err != nil {
+		return nil, err
+	}
+	preview := newAlertPreview(route, maskSecret(route.PostURL), payload)
+	preview.decide("template", "MessageCard", "Office 365 connector card, the only format of Teams incoming webhooks")
+	preview.decide("themeColor", alert.Metadata.StatusColor, "metadata.statusColor")
+	preview.decide("title", alert.Metadata.Status+": "+alert.Metadata.EventDescription, "metadata.status and metadata.eventDescription")
+	if alert.Metadata.StatusColor == "" {
+		preview.warn("metadata.statusColor is empty, Teams shows the card without a color")
+	}
+	if alert.Metadata.URL == "" {
+		preview.warn("metadata.url is empty, the View in HealthWatch button leads nowhere")
+	}
+	if alert.Metadata.DocsURL == "" {
+		preview.warn("metadata.docsUrl is empty, the Refer Documentation button leads nowhere")
+	}
+	return preview, nil
+}
+
+// previewPagerDuty : the Events API v2 event enqueued for route, compiled with the masked routing key
+func previewPagerDuty(route *routes, alert *helpers.EventAlert) (*alertPreview, error) {
+	msg := helpers.CompilePagerDutyMessage(alert, maskSecret(route.PostURL))
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	preview := newAlertPreview(route, helpers.PagerDutyURL, payload)
+	preview.decide("routing_key", msg.RoutingKey, "integration key of the "+route.Identifier+" mapping")
+	preview.decide("event_action", msg.EventAction, "every Event Alert triggers, repeated alerts open new incidents")
+	preview.decide("payload.severity", msg.Payload.Severity, fmt.Sprintf("metadata.status %q lowercased", alert.Metadata.Status))
+	preview.decide("payload.summary", msg.Payload.Summary, "metadata.foundation and metadata.eventDescription")
+	preview.decide("payload.source", msg.Payload.Source, "metadata.foundation")
+	if !validSeverity(msg.Payload.Severity) {
+		preview.warn(fmt.Sprintf("PagerDuty rejects severity %q, expected one of %s", msg.Payload.Severity, strings.Join(pagerDutySeverities, ", ")))
+	}
+	if msg.Payload.Source == "" {
+		preview.warn("metadata.foundation is empty, PagerDuty rejects events without a source")
+	}
+	return preview, nil
+}
+
+func newAlertPreview(route *routes, endpoint string, payload []byte) *alertPreview {
+	return &alertPreview{
+		RouteType:      route.RouteType,
+		Identifier:     route.Identifier,
+		Endpoint:       endpoint,
+		MappingVersion: route.Version,
+		Decisions:      []previewDecision{},
+		Payload:        payload,
+	}
+}
+
+func (preview *alertPreview) decide(field string, value string, source string) {
+	preview.Decisions = append(preview.Decisions, previewDecision{Field: field, Value: value, Source: source})
+}
+
+func (preview *alertPreview) warn(warning string) {
+	preview.Warnings = append(preview.Warnings, warning)
+}
+
+// validSeverity : whether PagerDuty accepts severity
+func validSeverity(severity string) bool {
+	for _, accepted := range pagerDutySeverities {
+		if severity == accepted {
+			return true
+		}
+	}
+	return false
+}
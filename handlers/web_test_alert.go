@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/tushardag/pcf-eventalert-integration/helpers"
+)
+
+const (
+	// testAlertInterval : a mapping is test-fired at most once per interval, per instance
+	testAlertInterval = time.Minute
+	// testAlertPublisher : labels every synthetic alert, in the Teams card and the PagerDuty client
+	testAlertPublisher = "pcf-eventalert-integration test"
+)
+
+// testStep : one call made to Teams or PagerDuty by a test alert
+type testStep struct {
+	Action string `json:"action"`
+	Status int    `json:"status"`
+	Body   string `json:"body,omitempty"`
+	Error  string `json:"error,omitempty"`
+}
+
+// testAlertResult : body of the test endpoint
+type testAlertResult struct {
+	RouteType  string     `json:"routeType"`
+	Identifier string     `json:"identifier"`
+	Endpoint   string     `json:"endpoint"`
+	Delivered  bool       `json:"delivered"`
+	Steps      []testStep `json:"steps"`
+}
+
+// testAlertLimiter : when each mapping was last test-fired
+type testAlertLimiter struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+}
+
+// allow : whether key may be test-fired now, and otherwise how long until it may
+func (limiter *testAlertLimiter) allow(key string, now time.Time) (bool, time.Duration) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	if limiter.last == nil {
+		limiter.last = make(map[string]time.Time)
+	}
+	if wait := limiter.last[key].Add(testAlertInterval).Sub(now); wait > 0 {
+		return false, wait
+	}
+	for k, fired := range limiter.last {
+		if now.Sub(fired) >= testAlertInterval {
+			delete(limiter.last, k)
+		}
+	}
+	limiter.last[key] = now
+	return true, 0
+}
+
+//TestAlert : POST request sending a clearly labelled synthetic alert through a mapping, and
+//answering with what Teams or PagerDuty replied. PagerDuty incidents are resolved right away.
+func (rh *RequestHandler) TestAlert(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	if vars["type"] != teamsType && vars["type"] != pagerdutyType {
+		writeError(w, r, http.StatusNotAcceptable, codeInvalidType, "Not a valid Type.")
+		return
+	}
+	route, err := rh.dbConn.getRoute(r.Context(), vars["identifier"], vars["type"])
+	if err != nil {
+		writeError(w, r, http.StatusNotFound, codeMappingNotFound, "Mapping not found for "+vars["identifier"])
+		return
+	}
+	if ok, wait := rh.testAlerts.allow(routeKey(route), time.Now()); !ok {
+		w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+		writeError(w, r, http.StatusTooManyRequests, codeRateLimited, "This mapping was tested less than a minute ago. Retry later")
+		return
+	}
+
+	ctx := withLogFields(r.Context(), slog.String("identifier", route.Identifier), slog.String("route_type", route.RouteType))
+	ctx = rh.deliveryContext(ctx, route.RouteType)
+	alert := syntheticAlert(route, requestActor(r))
+	result := &testAlertResult{RouteType: route.RouteType, Identifier: route.Identifier, Endpoint: maskSecret(route.PostURL)}
+	if route.RouteType == teamsType {
+		result.step("post", rh.deliverTest(ctx, route, func() (helpers.DeliveryResult, error) {
+			return helpers.CompileTeamsMessage(alert).PostMessage(ctx, route.PostURL)
+		}))
+	} else {
+		result.Endpoint = helpers.PagerDutyURL
+		dedupKey := fmt.Sprintf("eventalert-test-%s-%d", route.Identifier, time.Now().UnixNano())
+		trigger := helpers.CompilePagerDutyMessage(alert, route.PostURL).WithDedupKey(dedupKey)
+		if result.step("trigger", rh.deliverTest(ctx, route, func() (helpers.DeliveryResult, error) {
+			return trigger.CreateIncident(ctx)
+		})) {
+			result.step("resolve", rh.deliverTest(ctx, route, func() (helpers.DeliveryResult, error) {
+				return helpers.CompilePagerDutyResolve(route.PostURL, dedupKey).CreateIncident(ctx)
+			}))
+		}
+	}
+	slog.InfoContext(ctx, "Sent a test alert", "delivered", result.Delivered)
+	rh.auditTest(r, route, result.Steps)
+
+	if !result.Delivered && isAPIv1(r) {
+		writeAPIError(w, r, apiError{Code: codeDeliveryFailed, Message: "The test alert was not accepted", Details: result})
+		return
+	}
+	status := http.StatusOK
+	if !result.Delivered {
+		status = http.StatusBadGateway
+	}
+	writeData(w, r, status, result)
+}
+
+// deliverTest : one call of a test alert. Counted in the delivery metrics, but not tracked for
+// the shutdown, a spooled test could be triggered again without being resolved.
+func (rh *RequestHandler) deliverTest(ctx context.Context, route *routes, attempt func() (helpers.DeliveryResult, error)) testStep {
+	answer, err := rh.metrics.deliver(route.RouteType, attempt)
+	step := testStep{Status: answer.Status, Body: answer.Body}
+	if err != nil {
+		// The answer and the audit trail show the error, not the webhook URL or routing key in it
+		step.Error = deliveryError(route, err)
+		slog.WarnContext(ctx, "Test alert was not accepted", "error", step.Error)
+	}
+	return step
+}
+
+// step : add a call to the result, delivered as long as every call succeeded. Returns whether this one did.
+func (result *testAlertResult) step(action string, step testStep) bool {
+	step.Action = action
+	result.Steps = append(result.Steps, step)
+	result.Delivered = true
+	for _, previous := range result.Steps {
+		if previous.Error != "" {
+			result.Delivered = false
+		}
+	}
+	return step.Error == ""
+}
+
+// syntheticAlert : an Event Alert nobody can mistake for a real one
+func syntheticAlert(route *routes, actor string) *helpers.EventAlert {
+	alert := new(helpers.EventAlert)
+	alert.Publisher = testAlertPublisher
+	alert.Topic = "eventalert-integration.test"
+	alert.Metadata.Status = "Info"
+	alert.Metadata.StatusColor = "#1F77B4"
+	alert.Metadata.Foundation = "pcf-eventalert-integration"
+	alert.Metadata.EventType = "Test"
+	alert.Metadata.Value = time.Now().UTC().Format(time.RFC3339)
+	alert.Metadata.EventDescription = fmt.Sprintf("TEST ALERT for the %s mapping of %s, sent by %s. No action needed.",
+		route.RouteType, route.Identifier, actor)
+	alert.Metadata.DocsURL = "https://github.com/tushardag/pcf-eventalert-integration#interaction-instructions"
+	return alert
+}
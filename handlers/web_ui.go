@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+//UIPrefix : path the admin UI is served from
+const UIPrefix = "/ui/"
+
+// uiFiles : the admin UI, built into the binary so that air-gapped foundations get it too
+//
+//go:embed ui
+var uiFiles embed.FS
+
+//AdminUI : the embedded admin UI. The page holds no data, it calls /api/v1 with the admin token
+//entered in it, so the management endpoints apply the same auth as for any other client.
+func AdminUI() http.Handler {
+	files, err := fs.Sub(uiFiles, "ui")
+	if err != nil {
+		panic(err)
+	}
+	fileServer := http.StripPrefix(UIPrefix, http.FileServer(http.FS(files)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Nothing but the app itself may be loaded, and the page may not be framed
+		w.Header().Set("Content-Security-Policy", "default-src 'self'; frame-ancestors 'none'")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("Cache-Control", "no-cache")
+		fileServer.ServeHTTP(w, r)
+	})
+}
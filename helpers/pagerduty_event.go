@@ -2,23 +2,56 @@ package helpers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"io/ioutil"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const pagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+//PagerDutyURL : Events API v2 endpoint receiving every PagerDuty event
+const PagerDutyURL = "https://events.pagerduty.com/v2/enqueue"
+
+// maxResponseBody : how much of a Teams or PagerDuty answer is kept in a DeliveryResult
+const maxResponseBody = 4096
+
+//DeliveryResult : what Teams or PagerDuty answered to a delivery. Status is zero when it could not be reached.
+type DeliveryResult struct {
+	Status int    `json:"status"`
+	Body   string `json:"body,omitempty"`
+}
+
+//DeliveryTimeout : how long a delivery may take, kept under the shutdown drain timeout so that
+//a delivery started before the shutdown can finish
+const DeliveryTimeout = 5 * time.Second
+
+// deliveryClient : shared by Teams and PagerDuty, a hanging endpoint must not hold the alert forever
+var deliveryClient = &http.Client{Timeout: DeliveryTimeout}
+
+// tracer : spans of the helpers package, follows the globally installed provider
+var tracer = otel.Tracer("github.com/tushardag/pcf-eventalert-integration/helpers")
 
 //PDOutgoingMsg : type for PagerDuty message post request
 type pdOutgoingMsg struct {
-	Payload     payload `json:"payload"`
-	RoutingKey  string  `json:"routing_key"`
-	Links       []link  `json:"links"`
-	EventAction string  `json:"event_action"`
-	Client      string  `json:"client"`
-	ClientURL   string  `json:"client_url"`
+	Payload     *payload `json:"payload,omitempty"`
+	RoutingKey  string   `json:"routing_key"`
+	DedupKey    string   `json:"dedup_key,omitempty"`
+	Links       []link   `json:"links,omitempty"`
+	EventAction string   `json:"event_action"`
+	Client      string   `json:"client,omitempty"`
+	ClientURL   string   `json:"client_url,omitempty"`
 }
 
 type payload struct {
@@ -47,7 +80,7 @@ func CompilePagerDutyMessage(eventAlert *EventAlert, routingKey string) pdOutgoi
 	return pdOutgoingMsg{
 		RoutingKey:  routingKey,
 		EventAction: "trigger",
-		Payload: payload{
+		Payload: &payload{
 			Summary:   eventAlert.Metadata.Foundation + ": " + eventAlert.Metadata.EventDescription,
 			Source:    eventAlert.Metadata.Foundation,
 			Severity:  strings.ToLower(eventAlert.Metadata.Status),
@@ -71,22 +104,104 @@ func CompilePagerDutyMessage(eventAlert *EventAlert, routingKey string) pdOutgoi
 	}
 }
 
-//CreateIncident : Posting the message to MSTeams
-func (pd pdOutgoingMsg) CreateIncident() error {
+//CompilePagerDutyResolve : event resolving the incident triggered with dedupKey
+func CompilePagerDutyResolve(routingKey string, dedupKey string) pdOutgoingMsg {
+	return pdOutgoingMsg{
+		RoutingKey:  routingKey,
+		DedupKey:    dedupKey,
+		EventAction: "resolve",
+	}
+}
+
+//WithDedupKey : the same event under the given deduplication key, so it can be resolved later
+func (pd pdOutgoingMsg) WithDedupKey(dedupKey string) pdOutgoingMsg {
+	pd.DedupKey = dedupKey
+	return pd
+}
+
+//CreateIncident : Posting the event to PagerDuty, triggering or resolving an incident.
+func (pd pdOutgoingMsg) CreateIncident(ctx context.Context) (DeliveryResult, error) {
+	attrs := []attribute.KeyValue{attribute.String("pagerduty.event_action", pd.EventAction)}
+	if pd.Payload != nil {
+		attrs = append(attrs, attribute.String("pagerduty.severity", pd.Payload.Severity))
+	}
+	ctx, span := startDeliverySpan(ctx, "pagerduty.enqueue", PagerDutyURL, attrs...)
+	defer span.End()
+	result, err := pd.createIncident(ctx)
+	endSpan(span, result.Status, err)
+	return result, err
+}
+
+func (pd pdOutgoingMsg) createIncident(ctx context.Context) (DeliveryResult, error) {
 	enc, err := json.Marshal(pd)
 	if err != nil {
-		return err
+		return DeliveryResult{}, err
+	}
+	result, err := postJSON(ctx, PagerDutyURL, enc)
+	if err != nil {
+		return result, err
+	}
+
+	if result.Status >= 299 {
+		return result, fmt.Errorf("error in posting incident to PD: %d %s", result.Status, result.Body)
+	}
+	slog.InfoContext(ctx, "Successfully posted the message to PagerDuty", "event_action", pd.EventAction, "response", result.Status)
+	return result, nil
+}
+
+// startDeliverySpan : client span of one delivery. Only the host is recorded, webhook paths carry secrets.
+func startDeliverySpan(ctx context.Context, name string, endpoint string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	if u, err := url.Parse(endpoint); err == nil {
+		attrs = append(attrs, attribute.String("server.address", u.Hostname()))
+	}
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attrs...))
+}
+
+// endSpan : record the HTTP status, and the error of a failed delivery
+func endSpan(span trace.Span, status int, err error) {
+	if status != 0 {
+		span.SetAttributes(attribute.Int("http.status_code", status))
 	}
-	b := bytes.NewBuffer(enc)
-	res, err := http.Post(pagerDutyURL, "application/json", b)
 	if err != nil {
-		return err
+		err = spanError(err)
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
 	}
+}
 
-	if res.StatusCode >= 299 {
-		log.Printf("PD Request: %s", enc)
-		return fmt.Errorf("error in posting incident to PD: %s", res.Status)
+// spanError : err without the URL a transport error carries, as it holds the webhook path
+func spanError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+// propagationKey : marks a context whose deliveries carry the trace context
+type propagationKey struct{}
+
+//WithTracePropagation : deliveries made with the returned context send the W3C trace context
+//along in traceparent. Off by default, trace IDs are internal and Teams and PagerDuty ignore them.
+func WithTracePropagation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, propagationKey{}, true)
+}
+
+// postJSON : POST a JSON body with the delivery client, cancelled along with ctx
+func postJSON(ctx context.Context, endpoint string, body []byte) (DeliveryResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return DeliveryResult{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if propagate, _ := ctx.Value(propagationKey{}).(bool); propagate {
+		otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
+	}
+	res, err := deliveryClient.Do(req)
+	if err != nil {
+		return DeliveryResult{}, err
 	}
-	fmt.Printf("Successfully posted the message to PagerDuty. Response code: %s", res.Status)
-	return nil
+	defer res.Body.Close()
+	answer, _ := ioutil.ReadAll(io.LimitReader(res.Body, maxResponseBody))
+	return DeliveryResult{Status: res.StatusCode, Body: strings.TrimSpace(string(answer))}, nil
 }
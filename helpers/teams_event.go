@@ -1,10 +1,10 @@
 package helpers
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
-	"net/http"
+	"log/slog"
 )
 
 //TeamsOutgoingMsg : type for Teams message post request
@@ -105,21 +105,28 @@ func CompileTeamsMessage(eventAlert *EventAlert) teamsOutgoingMsg {
 	}
 }
 
-//PostMessage : Posting the message to MSTeams
-func (msg teamsOutgoingMsg) PostMessage(endpoint string) error {
+//PostMessage : Posting the message to MSTeams.
+func (msg teamsOutgoingMsg) PostMessage(ctx context.Context, endpoint string) (DeliveryResult, error) {
+	ctx, span := startDeliverySpan(ctx, "teams.post", endpoint)
+	defer span.End()
+	result, err := msg.postMessage(ctx, endpoint)
+	endSpan(span, result.Status, err)
+	return result, err
+}
+
+func (msg teamsOutgoingMsg) postMessage(ctx context.Context, endpoint string) (DeliveryResult, error) {
 	enc, err := json.Marshal(msg)
 	if err != nil {
-		return err
+		return DeliveryResult{}, err
 	}
-	b := bytes.NewBuffer(enc)
-	res, err := http.Post(endpoint, "application/json", b)
+	result, err := postJSON(ctx, endpoint, enc)
 	if err != nil {
-		return err
+		return result, err
 	}
 
-	if res.StatusCode >= 299 {
-		return fmt.Errorf("error on message: %s", res.Status)
+	if result.Status >= 299 {
+		return result, fmt.Errorf("error on message: %d %s", result.Status, result.Body)
 	}
-	fmt.Printf("Successfully posted the message to MSTeam. Response code: %s", res.Status)
-	return nil
+	slog.InfoContext(ctx, "Successfully posted the message to MSTeam", "response", result.Status)
+	return result, nil
 }
@@ -0,0 +1,67 @@
+package helpers
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+//ServiceBinding : one service bound to the app, as listed in VCAP_SERVICES
+type ServiceBinding struct {
+	Name         string                 `json:"name"`
+	InstanceName string                 `json:"instance_name"`
+	Label        string                 `json:"label"`
+	Tags         []string               `json:"tags"`
+	Credentials  map[string]interface{} `json:"credentials"`
+}
+
+//VCAPServices : parsed VCAP_SERVICES, bindings grouped by service label
+type VCAPServices map[string][]ServiceBinding
+
+//ParseVCAPServices : read VCAP_SERVICES from the environment, empty when not running in PCF
+func ParseVCAPServices() (VCAPServices, error) {
+	services := make(VCAPServices)
+	env := os.Getenv("VCAP_SERVICES")
+	if env == "" {
+		return services, nil
+	}
+	if err := json.Unmarshal([]byte(env), &services); err != nil {
+		return nil, fmt.Errorf("vcap: could not parse VCAP_SERVICES: %v", err)
+	}
+	return services, nil
+}
+
+//Binding : the bound service with the given name or instance name
+func (services VCAPServices) Binding(name string) (ServiceBinding, bool) {
+	for _, bindings := range services {
+		for _, binding := range bindings {
+			if binding.Name == name || binding.InstanceName == name {
+				return binding, true
+			}
+		}
+	}
+	return ServiceBinding{}, false
+}
+
+//Credential : a credentials value by its dotted path, e.g. "uri" or "webhooks.teams"
+func (binding ServiceBinding) Credential(path string) (string, error) {
+	var value interface{} = binding.Credentials
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("vcap: %s credential %s is not an object", binding.Name, path)
+		}
+		if value, ok = object[key]; !ok {
+			return "", fmt.Errorf("vcap: service %s has no credential %s", binding.Name, path)
+		}
+	}
+	switch v := value.(type) {
+	case string:
+		return v, nil
+	case float64, bool:
+		return fmt.Sprint(v), nil
+	default:
+		return "", fmt.Errorf("vcap: %s credential %s is not a single value", binding.Name, path)
+	}
+}
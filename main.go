@@ -1,14 +1,12 @@
 package main
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
 	"time"
 
@@ -16,69 +14,81 @@ import (
 	"github.com/tushardag/pcf-eventalert-integration/handlers"
 )
 
+// version : set at build time, go build -ldflags "-X main.version=1.4.0"
+var version = "dev"
+
+const (
+	configFile = "application.yml"
+	// configWatchInterval : how often application.yml is checked for changes
+	configWatchInterval = 10 * time.Second
+)
+
 func main() {
-	fmt.Println("Starting up the pcf-eventalert-integration server...")
-	fmt.Println("Validating Application config.")
+	// "validate [--resolve] [file]" checks a config without starting the server
+	if len(os.Args) > 1 && os.Args[1] == "validate" {
+		os.Exit(validateCommand(os.Args[2:]))
+	}
+
+	// JSON at info level until the logging block of application.yml is read
+	handlers.ConfigureLogging("json", "info")
+	slog.Info("Starting up the pcf-eventalert-integration server...")
+	slog.Info("Validating Application config.")
 
-	ymlFile, err := ioutil.ReadFile("application.yml")
+	ymlFile, err := ioutil.ReadFile(configFile)
 	if err != nil {
-		log.Println("Error in reading application config.")
-		log.Fatalln(err)
+		fatal("Error in reading application config.", err)
 	}
 
-	// For the local instance of mysql, update the username,
+	// For the local instance of mysql or postgres, update the username,
 	// password and instance connection string. When running locally,
-	// localhost:3306 is used
-	var mysqlDBconfig handlers.MySQLConfig
-	mysqlDBconfig, err = configureMySQL()
-	//fmt.Printf("Connecting to MySQL Host %s on port %d \n", mysqlDBconfig.Host, mysqlDBconfig.Port)
-	requestHandler, err := handlers.RequestHandlerInit(mysqlDBconfig, ymlFile)
+	// localhost on the engine's default port is used
+	dbConfig := configureDatabase()
+	requestHandler, err := handlers.RequestHandlerInit(dbConfig, ymlFile)
 	if err != nil {
-		log.Fatalln(err)
+		fatal("Unable to initialize the request handler.", err)
 	}
-	defer requestHandler.CloseDB()
+	requestHandler.WatchConfig(configFile, configWatchInterval)
 
-	fmt.Println("Initializing the webserver process.")
+	slog.Info("Initializing the webserver process.")
 	router := mux.NewRouter()
-
-	// list out the routes and usages information
-	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		// Print the routes and help information for app usages
-		fmt.Fprintln(w, "{type} ==> teams or pagerduty")
-		fmt.Fprintln(w, "{identifier} ==> unique tag for respective teams/pagerduty endpoint")
-		router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
-			pathTemplate, err := route.GetPathTemplate()
-			if err == nil {
-				fmt.Fprintf(w, "ROUTE \"%s\" is servicing ", pathTemplate)
-			}
-			methods, err := route.GetMethods()
-			if err == nil {
-				fmt.Fprintf(w, "on HTTP method %s", strings.Join(methods, ","))
-			}
-			fmt.Fprintln(w)
-			return nil
-		})
-	}).Methods("GET")
-
-	// Fetch the list of existing route mappings from DB in JSON format
-	router.HandleFunc("/routes", requestHandler.ListMappings).Methods("GET")
-	// Supress the mapping management for non-db mode
-	if requestHandler.DBinUse() {
-		router.HandleFunc("/{type}/{identifier}", requestHandler.CreatMapping).Methods("PUT")
-		router.HandleFunc("/{type}/{identifier}", requestHandler.RemoveMapping).Methods("DELETE")
+	// Every request gets a span and an X-Request-ID, both carried by its log lines
+	router.Use(handlers.TraceRequests, handlers.RequestID)
+
+	// Routes, version and features as JSON, a help page with the webhook URLs as HTML, or the
+	// plain text listing
+	router.HandleFunc("/", requestHandler.Discovery(router, version)).Methods("GET")
+
+	router.HandleFunc("/healthz", requestHandler.Liveness).Methods("GET")
+	router.HandleFunc("/readyz", requestHandler.Readiness).Methods("GET")
+	router.Handle("/metrics", requestHandler.MetricsHandler()).Methods("GET")
+
+	// Versioned JSON API, registered first so that no legacy path shadows it
+	api := router.PathPrefix(handlers.APIPrefix).Subrouter()
+	api.Use(handlers.APIv1)
+	api.HandleFunc("/openapi.json", requestHandler.OpenAPI).Methods("GET")
+	apiRoutes := requestHandler.APIRoutes()
+	for _, route := range apiRoutes {
+		api.HandleFunc(route.Path, route.Handler).Methods(route.Method)
+	}
+	// Admin UI, ahead of the legacy /{type}/{identifier} its files would match
+	router.Handle("/ui", http.RedirectHandler(handlers.UIPrefix, http.StatusMovedPermanently)).Methods("GET")
+	router.PathPrefix(handlers.UIPrefix).Handler(handlers.AdminUI()).Methods("GET")
+
+	// The paths predating /api/v1 keep working, flagged as deprecated
+	for _, route := range apiRoutes {
+		if route.Legacy != "" {
+			router.Handle(route.Legacy, handlers.Deprecated(route.Path, route.Handler)).Methods(route.Method)
+		}
 	}
-	//MS Teams Event routing
-	router.HandleFunc("/teams/{identifier}", requestHandler.MSTeamsAlert).Methods("POST")
-	//PagerDuty Event routing
-	router.HandleFunc("/pagerduty/{identifier}", requestHandler.PagerDutyAlert).Methods("POST")
 
 	router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
 		path, err := route.GetPathTemplate()
 		method, err := route.GetMethods()
 		if err != nil {
-			return err
+			// The /api/v1 prefix only groups the routes below it
+			return nil
 		}
-		fmt.Printf("Enabling route: %s for method %s\n", path, method)
+		slog.Info("Enabling route", "path", path, "methods", method)
 		return nil
 	})
 
@@ -92,24 +102,64 @@ func main() {
 		Handler:      router, // Pass our instance of gorilla/mux in.
 	}
 
-	// Handling gracefull shutdown of the server
-	var gracefulStop = make(chan os.Signal)
+	// Reload application.yml on SIGHUP
+	var reload = make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			slog.Info("caught SIGHUP, reloading the config", "path", configFile)
+			requestHandler.ReloadConfig()
+		}
+	}()
+
+	// Handling gracefull shutdown of the server: in-flight requests and deliveries are drained
+	// before the DB is closed
+	var gracefulStop = make(chan os.Signal, 1)
 	signal.Notify(gracefulStop, syscall.SIGTERM)
 	signal.Notify(gracefulStop, syscall.SIGINT)
+	stopped := make(chan struct{})
 	go func() {
 		sig := <-gracefulStop
-		log.Printf("caught sig: %+v", sig)
-		fmt.Println("Wait for 2 second to finish processing")
-		time.Sleep(2 * time.Second)
-		fmt.Println("Shutting down the server process")
-		os.Exit(0)
+		slog.Info("caught signal, shutting down the server process", "signal", sig.String())
+		requestHandler.Shutdown(server)
+		close(stopped)
 	}()
 
 	//Starting the webserver
-	if err := server.ListenAndServe(); err != nil {
-		log.Printf("Unable to start the pcf-eventalert-integration server.")
-		log.Fatalln(err)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		fatal("Unable to start the pcf-eventalert-integration server.", err)
 	}
+	<-stopped
+}
+
+// fatal : log the error and exit, deferred calls do not run
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
+
+// validateCommand : report every problem in the given config file, application.yml by default.
+// References are only checked for syntax unless --resolve is given, as on the platform.
+func validateCommand(args []string) int {
+	resolve := false
+	if len(args) > 0 && args[0] == "--resolve" {
+		resolve, args = true, args[1:]
+	}
+	file := configFile
+	if len(args) > 0 {
+		file = args[0]
+	}
+	ymlFile, err := ioutil.ReadFile(file)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := handlers.ValidateConfig(ymlFile, resolve); err != nil {
+		fmt.Fprintf(os.Stderr, "%s is invalid:\n%v\n", file, err)
+		return 1
+	}
+	fmt.Printf("%s is valid\n", file)
+	return 0
 }
 
 func getPort() string {
@@ -120,48 +170,12 @@ func getPort() string {
 	return port
 }
 
-type mysqlDBInfo struct {
-	Credentials mysqlDBCredentials `json:"credentials"`
-}
-
-type mysqlDBCredentials struct {
-	Host     string `json:"hostname"`
-	Port     int    `json:"port"`
-	User     string `json:"username"`
-	Password string `json:"password"`
-}
-
-func configureMySQL() (handlers.MySQLConfig, error) {
-	if os.Getenv("VCAP_SERVICES") != "" {
-		// Running in PCF.
-		s := os.Getenv("VCAP_SERVICES")
-		services := make(map[string][]mysqlDBInfo)
-		err := json.Unmarshal([]byte(s), &services)
-		if err != nil {
-			log.Printf("Error parsing MySQL connection information: %v\n", err.Error())
-			return handlers.MySQLConfig{}, err
-		}
-		info := services["p.mysql"]
-		if len(info) == 0 {
-			log.Printf("No MySQL databases are bound to this application.\n")
-			return handlers.MySQLConfig{}, fmt.Errorf("unable to find service with name p.mysql")
-		}
-		// Assumes only a single MySQLDB is bound to this application
-		creds := info[0].Credentials
-		//fmt.Println(creds)
-		return handlers.MySQLConfig{
-			Username: creds.User,
-			Password: creds.Password,
-			Host:     creds.Host,
-			Port:     creds.Port,
-		}, nil
-	}
-
-	// Running locally.
-	return handlers.MySQLConfig{
+// configureDatabase : the local database. In PCF, the bound service named by
+// database_service in application.yml is used instead.
+func configureDatabase() handlers.DBConfig {
+	return handlers.DBConfig{
 		Username: "mapper",
 		Password: "mapper",
 		Host:     "localhost",
-		Port:     3306,
-	}, nil
+	}
 }